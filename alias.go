@@ -0,0 +1,142 @@
+package senpai
+
+import (
+	"fmt"
+	"strings"
+)
+
+// newAlias returns the command of an alias to expansion, see expandAlias.
+func newAlias(expansion string) *command {
+	minArgs, rest := aliasArgs(expansion)
+	var usage []string
+	for i := 1; i <= minArgs; i++ {
+		usage = append(usage, fmt.Sprintf("<arg%d>", i))
+	}
+	if rest {
+		usage = append(usage, "[args...]")
+	}
+	return &command{
+		AllowHome: true,
+		MinArgs:   minArgs,
+		Usage:     strings.Join(usage, " "),
+		Desc:      fmt.Sprintf("alias for %q", expansion),
+		Alias:     expansion,
+	}
+}
+
+// aliasArgs returns the number of arguments required by expansion, and
+// whether it accepts more.
+func aliasArgs(expansion string) (minArgs int, rest bool) {
+	for i := 0; i+1 < len(expansion); i++ {
+		if expansion[i] != '$' {
+			continue
+		}
+		i++
+		switch c := expansion[i]; {
+		case c == '*':
+			rest = true
+		case '1' <= c && c <= '9':
+			n := int(c - '0')
+			if i+1 < len(expansion) && expansion[i+1] == '*' {
+				rest = true
+				n--
+				i++
+			}
+			if minArgs < n {
+				minArgs = n
+			}
+		}
+	}
+	return minArgs, rest
+}
+
+// expandAlias returns the commands of expansion, separated by semicolons, with
+// $1 to $9 replaced by the argument of the same position in rawArgs, $2* to
+// $9* by the arguments from that position, $* by all arguments, and $$ by a
+// dollar sign.
+func expandAlias(expansion, rawArgs string) []string {
+	args := strings.Fields(rawArgs)
+	var lines []string
+	for _, part := range strings.Split(expansion, ";") {
+		var sb strings.Builder
+		for i := 0; i < len(part); i++ {
+			if part[i] != '$' || i+1 == len(part) {
+				sb.WriteByte(part[i])
+				continue
+			}
+			i++
+			switch c := part[i]; {
+			case c == '$':
+				sb.WriteByte('$')
+			case c == '*':
+				sb.WriteString(strings.TrimSpace(rawArgs))
+			case '1' <= c && c <= '9':
+				n := int(c - '0')
+				if i+1 < len(part) && part[i+1] == '*' {
+					i++
+					if fields := fieldsN(rawArgs, n); len(fields) == n {
+						sb.WriteString(fields[n-1])
+					}
+				} else if n <= len(args) {
+					sb.WriteString(args[n-1])
+				}
+			default:
+				sb.WriteByte('$')
+				sb.WriteByte(c)
+			}
+		}
+		if line := strings.TrimSpace(sb.String()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// runAlias runs the commands of the alias name.  While they run, name refers
+// to the built-in command it shadows, if any, so that aliases cannot expand
+// recursively.
+func (app *App) runAlias(buffer, name string, cmd *command, rawArgs string) error {
+	if len(strings.Fields(rawArgs)) < cmd.MinArgs {
+		return fmt.Errorf("usage: %s %s", name, cmd.Usage)
+	}
+	app.expanding[name] = true
+	defer delete(app.expanding, name)
+	for _, line := range expandAlias(cmd.Alias, rawArgs) {
+		if err := app.handleInput(buffer, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findCommand returns the command of the given name or, if there is only one,
+// the command starting with it.
+func (app *App) findCommand(cmdName string) (string, *command, error) {
+	chosenCMDName := cmdName
+	if _, ok := app.commands[cmdName]; !ok {
+		var found bool
+		for key := range app.commands {
+			if !strings.HasPrefix(key, cmdName) {
+				continue
+			}
+			if found {
+				return "", nil, fmt.Errorf("ambiguous command %q (could mean %v or %v)", cmdName, chosenCMDName, key)
+			}
+			chosenCMDName = key
+			found = true
+		}
+		if !found {
+			return "", nil, fmt.Errorf("command %q doesn't exist", cmdName)
+		}
+	}
+
+	cmd := app.commands[chosenCMDName]
+	if cmd.Alias != "" && app.expanding[chosenCMDName] {
+		builtin, ok := commands[chosenCMDName]
+		if !ok {
+			return "", nil, fmt.Errorf("alias %s is used recursively", chosenCMDName)
+		}
+		cmd = builtin
+	}
+	return chosenCMDName, cmd, nil
+}
@@ -0,0 +1,71 @@
+package senpai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandAlias(t *testing.T) {
+	for _, tc := range []struct {
+		expansion string
+		args      string
+		expected  []string
+	}{
+		{"/join $1", "#senpai", []string{"/join #senpai"}},
+		{"/msg NickServ $*", "identify  hunter2 ", []string{"/msg NickServ identify  hunter2"}},
+		{"/msg $1 $2*", "dan hello  there", []string{"/msg dan hello  there"}},
+		{"/join $1; /topic $2*", "#senpai", []string{"/join #senpai", "/topic"}},
+		{"/me pays $$1 to $1", "dan", []string{"/me pays $1 to dan"}},
+		{"/me is at 100$", "", []string{"/me is at 100$"}},
+	} {
+		if actual := expandAlias(tc.expansion, tc.args); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%q with %q: expected %q, got %q", tc.expansion, tc.args, tc.expected, actual)
+		}
+	}
+}
+
+func TestAliasArgs(t *testing.T) {
+	for expansion, expected := range map[string]struct {
+		minArgs int
+		rest    bool
+	}{
+		"/join $1":             {1, false},
+		"/msg NickServ $*":     {0, true},
+		"/msg $1 $2*":          {1, true},
+		"/kick $2 $1; /ban $2": {2, false},
+		"/me costs $$3":        {0, false},
+	} {
+		minArgs, rest := aliasArgs(expansion)
+		if minArgs != expected.minArgs || rest != expected.rest {
+			t.Errorf("%q: expected %d, %v, got %d, %v", expansion, expected.minArgs, expected.rest, minArgs, rest)
+		}
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	app := &App{
+		commands: commandSet{
+			"J":     newAlias("/join $1"),
+			"JOIN":  commands["JOIN"],
+			"LOOP":  newAlias("/loop"),
+			"NAMES": newAlias("/names; /topic"),
+		},
+		expanding: map[string]bool{},
+	}
+
+	if name, _, err := app.findCommand("J"); err != nil || name != "J" {
+		t.Errorf("expected the alias J, got %q, %v", name, err)
+	}
+	if name, _, err := app.findCommand("JO"); err != nil || name != "JOIN" {
+		t.Errorf("expected JOIN, got %q, %v", name, err)
+	}
+
+	app.expanding["LOOP"] = true
+	if _, _, err := app.findCommand("LOOP"); err == nil {
+		t.Errorf("expected an error for a recursive alias")
+	}
+	app.expanding["NAMES"] = true
+	if _, cmd, err := app.findCommand("NAMES"); err != nil || cmd != commands["NAMES"] {
+		t.Errorf("expected the built-in NAMES while expanding the alias, got %v", err)
+	}
+}
@@ -2,17 +2,19 @@ package senpai
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 
-	"golang.org/x/net/proxy"
-
 	"git.sr.ht/~taiite/senpai/irc"
 	"git.sr.ht/~taiite/senpai/ui"
 	"github.com/gdamore/tcell/v2"
@@ -77,19 +79,50 @@ type event struct {
 	content interface{}
 }
 
+// bouncerNetwork is a network added from the bouncer of another connection.
+type bouncerNetwork struct {
+	parentID string // netID of the connection to the bouncer.
+	id       string // ID of the network on the bouncer.
+	name     string // name of the network on the bouncer.
+	state    string // state of the connection of the bouncer to the network.
+}
+
 type boundKey struct {
 	netID  string
 	target string
 }
 
+type ctcpKey struct {
+	netID   string
+	target  string
+	command string
+}
+
+// ctcpTimeout is how long CTCP requests wait for a reply.
+const ctcpTimeout = 5 * time.Minute
+
+// addCTCPRequest records a CTCP request sent at t, and forgets requests that
+// got no reply in time.
+func (app *App) addCTCPRequest(key ctcpKey, t time.Time) {
+	for k, sent := range app.ctcpRequests {
+		if t.Sub(sent) > ctcpTimeout {
+			delete(app.ctcpRequests, k)
+		}
+	}
+	app.ctcpRequests[key] = t
+}
+
 type App struct {
 	win      *ui.UI
 	sessions map[string]*irc.Session
 	pasting  bool
 	events   chan event
 
-	cfg        Config
-	highlights []string
+	cfg             Config
+	networks        map[string]ConfigNetwork  // configuration of each network, by netID.
+	netLoops        map[string]*netLoop       // connection loop of each network, by netID.
+	bouncerNetworks map[string]bouncerNetwork // networks added from bouncers, by netID.
+	ignores         []Ignore
 
 	lastQuery     string
 	lastQueryNet  string
@@ -101,6 +134,33 @@ type App struct {
 
 	monitor map[string]map[string]struct{} // set of targets we want to monitor per netID, best-effort. netID->target->{}
 
+	autoAwayTimer *time.Timer         // fires after cfg.AutoAway without keyboard input.
+	autoAwayNets  map[string]struct{} // set of netIDs marked as away by auto-away.
+
+	ctcpRequests map[ctcpKey]time.Time // sent CTCP requests waiting for a reply.
+
+	stsMu       sync.Mutex           // guards stsPolicies, used by app.ircLoop.
+	stsPolicies map[string]STSPolicy // STS policies by host.
+
+	certsMu      sync.Mutex        // guards trustedCerts, used by app.ircLoop.
+	trustedCerts map[string]string // SHA-256 fingerprints of trusted certificates, by address.
+	certPrompts  []*certPrompt     // certificates waiting for the user to accept them.
+	certRoots    *x509.CertPool    // roots verifying certificates, the system ones if nil.
+
+	pasteConfirm *pasteConfirmation // paste waiting for confirmation, if any.
+	pasteQueue   []pastedLine       // pasted lines waiting to be sent.
+	pasteTimer   *time.Timer        // running until the next pasted line can be sent.
+	refreshTimer *time.Timer        // running until the interface is drawn again, see refreshLater.
+
+	log *logger // local message log, if enabled.
+
+	bindings map[Key]string // actions or commands bound to keys.
+
+	commands  commandSet      // built-in commands and aliases, by name.
+	expanding map[string]bool // aliases being expanded, see runAlias.
+
+	notifyLevels map[string]map[string]ui.NotifyLevel // levels set with /NOTIFY, by netID and lowercase buffer.
+
 	lastMessageTime time.Time
 	lastCloseTime   time.Time
 }
@@ -110,16 +170,33 @@ func NewApp(cfg Config) (app *App, err error) {
 		sessions:                  map[string]*irc.Session{},
 		events:                    make(chan event, eventChanSize),
 		cfg:                       cfg,
+		networks:                  map[string]ConfigNetwork{},
+		netLoops:                  map[string]*netLoop{},
+		bouncerNetworks:           map[string]bouncerNetwork{},
 		messageBounds:             map[boundKey]bound{},
 		monitor:                   make(map[string]map[string]struct{}),
+		autoAwayNets:              map[string]struct{}{},
+		ctcpRequests:              map[ctcpKey]time.Time{},
+		stsPolicies:               map[string]STSPolicy{},
+		trustedCerts:              map[string]string{},
+		notifyLevels:              map[string]map[string]ui.NotifyLevel{},
 		bufferBeforeCyclingUnread: -1,
 	}
 
-	if cfg.Highlights != nil {
-		app.highlights = make([]string, len(cfg.Highlights))
-		for i := range app.highlights {
-			app.highlights[i] = strings.ToLower(cfg.Highlights[i])
-		}
+	app.ignores = append(app.ignores, cfg.Ignores...)
+	app.initBindings()
+
+	app.commands = make(commandSet, len(commands)+len(cfg.Aliases))
+	for name, cmd := range commands {
+		app.commands[name] = cmd
+	}
+	for name, expansion := range cfg.Aliases {
+		app.commands[name] = newAlias(expansion)
+	}
+	app.expanding = map[string]bool{}
+
+	if cfg.LogFormat != "" {
+		app.log = newLogger(cfg.LogDir, cfg.LogFormat, cfg.LogKeep)
 	}
 
 	mouse := cfg.Mouse
@@ -137,9 +214,10 @@ func NewApp(cfg Config) (app *App, err error) {
 		MergeLine: func(former *ui.Line, addition ui.Line) {
 			app.mergeLine(former, addition)
 		},
-		Colors: ui.ConfigColors{
-			Unread: cfg.Colors.Unread,
+		NotifyLevel: func(netID, buffer string) ui.NotifyLevel {
+			return app.notifyLevel(netID, buffer)
 		},
+		Theme: cfg.Theme,
 	})
 	if err != nil {
 		return
@@ -147,7 +225,7 @@ func NewApp(cfg Config) (app *App, err error) {
 	app.win.SetPrompt(ui.Styled(">",
 		tcell.
 			StyleDefault.
-			Foreground(tcell.Color(app.cfg.Colors.Prompt))),
+			Foreground(tcell.Color(app.cfg.Theme.Prompt))),
 	)
 
 	app.initWindow()
@@ -176,7 +254,20 @@ func (app *App) Run() {
 		app.lastCloseTime = time.Now()
 	}
 	go app.uiLoop()
-	go app.ircLoop("")
+	if app.cfg.AutoAway != 0 {
+		app.autoAwayTimer = time.AfterFunc(app.cfg.AutoAway, func() {
+			app.events <- event{
+				src:     "*",
+				content: autoAway{},
+			}
+		})
+	}
+	if app.cfg.Addr != "" {
+		app.startNetwork("", app.cfg.ConfigNetwork, "")
+	}
+	for _, network := range app.cfg.Networks {
+		app.startNetwork(network.Name, network, "")
+	}
 	app.eventLoop()
 }
 
@@ -201,6 +292,11 @@ func (app *App) SetLastClose(t time.Time) {
 // them, then draws the interface after each batch is handled.
 func (app *App) eventLoop() {
 	defer app.win.Close()
+	defer func() {
+		if app.log != nil {
+			app.log.close()
+		}
+	}()
 
 	for !app.win.ShouldExit() {
 		ev := <-app.events
@@ -233,6 +329,12 @@ func (app *App) eventLoop() {
 				}
 			}
 			app.setStatus()
+			for _, loop := range app.netLoops {
+				if !loop.retryAt.IsZero() {
+					// Update the countdown in the buffer list.
+					app.refreshLater()
+				}
+			}
 			app.updatePrompt()
 			app.setBufferNumbers()
 			var currentMembers []irc.Member
@@ -264,105 +366,308 @@ func (app *App) handleEvent(ev event) bool {
 	return true
 }
 
-// ircLoop maintains a connection to the IRC server by connecting and then
-// forwarding IRC events to app.events repeatedly.
-func (app *App) ircLoop(netID string) {
-	var auth irc.SASLClient
-	if app.cfg.Password != nil {
-		auth = &irc.SASLPlain{
-			Username: app.cfg.User,
-			Password: *app.cfg.Password,
+// startNetwork starts connecting to network in the background.
+func (app *App) startNetwork(netID string, network ConfigNetwork, bouncerID string) {
+	loop := newNetLoop()
+	app.networks[netID] = network
+	app.netLoops[netID] = loop
+	app.setNetworkState(netID)
+	go app.ircLoop(netID, network, bouncerID, loop)
+}
+
+// removeNetwork disconnects from netID for good and removes its buffers.
+func (app *App) removeNetwork(netID string) {
+	if loop, ok := app.netLoops[netID]; ok {
+		close(loop.stop)
+		delete(app.netLoops, netID)
+	}
+	if s, ok := app.sessions[netID]; ok {
+		s.Close()
+		delete(app.sessions, netID)
+	}
+	delete(app.networks, netID)
+	delete(app.bouncerNetworks, netID)
+	delete(app.monitor, netID)
+	app.removeCertPrompts(netID)
+	app.win.RemoveNetwork(netID)
+}
+
+// closeSession closes the connection to netID, if any.  Its connection loop
+// then connects again, unless paused.
+func (app *App) closeSession(netID string) {
+	if s, ok := app.sessions[netID]; ok {
+		s.Close()
+		delete(app.sessions, netID)
+	}
+	app.setNetworkState(netID)
+}
+
+// setNetworkState shows in the buffer list the state of the connection to
+// netID and, for bouncer networks, of the bouncer to the network.
+func (app *App) setNetworkState(netID string) {
+	state := ui.NetworkDisconnected
+	if s, ok := app.sessions[netID]; ok {
+		state = ui.NetworkConnecting
+		if s.Registered() {
+			state = ui.NetworkConnected
 		}
 	}
+	if state == ui.NetworkConnected {
+		switch app.bouncerNetworks[netID].state {
+		case "connecting":
+			state = ui.NetworkConnecting
+		case "disconnected":
+			state = ui.NetworkDisconnected
+		}
+	}
+	app.win.SetNetworkState(netID, state)
+}
+
+// netLoop controls the connection loop of a network, see App.ircLoop.
+type netLoop struct {
+	stop       chan struct{} // closed to stop the loop for good.
+	wake       chan struct{} // to stop waiting before the next attempt.
+	registered chan struct{} // to reset the backoff after a registration.
+	paused     int32         // if 1, stay disconnected until woken up.
+
+	retryAt time.Time // time of the next attempt, if any, set by app.eventLoop.
+}
+
+func newNetLoop() *netLoop {
+	return &netLoop{
+		stop:       make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+		registered: make(chan struct{}, 1),
+	}
+}
+
+// setPaused changes whether the loop stays disconnected, and wakes it up.
+func (loop *netLoop) setPaused(paused bool) {
+	var p int32
+	if paused {
+		p = 1
+	}
+	atomic.StoreInt32(&loop.paused, p)
+	notify(loop.wake)
+}
+
+func (loop *netLoop) isPaused() bool {
+	return atomic.LoadInt32(&loop.paused) == 1
+}
+
+// notify sends to c without blocking, c being buffered.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// netRetry is sent by App.ircLoop when it waits before connecting again, with
+// the time of the next attempt, and with a zero time when it stops waiting or
+// waits to be woken up.
+type netRetry struct {
+	at time.Time
+}
+
+// ircLoop maintains a connection to the IRC server of network by connecting
+// and then forwarding IRC events to app.events repeatedly, until loop.stop is
+// closed.  bouncerID is the ID of the bouncer network to bind to, if any.
+func (app *App) ircLoop(netID string, network ConfigNetwork, bouncerID string, loop *netLoop) {
 	params := irc.SessionParams{
-		Nickname: app.cfg.Nick,
-		Username: app.cfg.User,
-		RealName: app.cfg.Real,
-		NetID:    netID,
-		Auth:     auth,
+		Nickname:     network.Nick,
+		AltNicknames: network.AltNicks,
+		Username:     network.User,
+		RealName:     network.Real,
+		NetID:        bouncerID,
+		CTCPVersion:  version(),
+		// Ignored users must not learn that they are read.
+		IgnoreCTCP: func(user *irc.Prefix, channel string) bool {
+			return app.isIgnored(netID, channel, user, IgnoreMessages)
+		},
+	}
+	if auths := saslClients(network); len(auths) != 0 {
+		params.Auth = auths[0]
+		params.AuthFallbacks = auths[1:]
+	}
+	b := backoff{
+		min: 2 * time.Second,
+		max: 5 * time.Minute,
 	}
 	for !app.win.ShouldExit() {
-		conn := app.connect(netID)
-		in, out := irc.ChanInOut(conn)
-		if app.cfg.Debug {
-			out = app.debugOutputMessages(netID, out)
-		}
-		session := irc.NewSession(out, params)
-		app.events <- event{
-			src:     netID,
-			content: session,
-		}
-		go func() {
-			for stop := range session.TypingStops() {
-				app.events <- event{
-					src:     netID,
-					content: stop,
+		if !loop.isPaused() {
+			select {
+			case <-loop.wake:
+			default:
+			}
+			if conn := app.connect(netID, network, loop.stop); conn != nil {
+				if loop.isPaused() {
+					conn.Close()
+				} else {
+					app.runSession(netID, conn, params)
 				}
 			}
-		}()
-		for msg := range in {
-			if app.cfg.Debug {
-				app.queueStatusLine(netID, ui.Line{
-					At:   time.Now(),
-					Head: "IN --",
-					Body: ui.PlainString(msg.String()),
-				})
+			select {
+			case <-loop.registered:
+				b.reset()
+			default:
+			}
+			if app.win.ShouldExit() {
+				break
 			}
+		}
+		if !app.waitRetry(netID, loop, &b) {
+			return
+		}
+	}
+}
+
+// runSession forwards the IRC events of conn to app.events, until the
+// connection is lost.
+func (app *App) runSession(netID string, conn net.Conn, params irc.SessionParams) {
+	in, out, c := irc.ChanInOut(conn, app.cfg.Flood)
+	if app.cfg.Debug {
+		out = app.debugOutputMessages(netID, out)
+	}
+	params.Conn = c
+	session := irc.NewSession(out, params)
+	app.events <- event{
+		src:     netID,
+		content: session,
+	}
+	go func() {
+		for stop := range session.TypingStops() {
 			app.events <- event{
 				src:     netID,
-				content: msg,
+				content: stop,
 			}
 		}
+	}()
+	for msg := range in {
+		if app.cfg.Debug {
+			app.queueStatusLine(netID, ui.Line{
+				At:   time.Now(),
+				Head: "IN --",
+				Body: ui.PlainString(msg.String()),
+			})
+		}
 		app.events <- event{
 			src:     netID,
-			content: nil,
+			content: msg,
 		}
-		app.queueStatusLine(netID, ui.Line{
-			Head:      "!!",
-			HeadColor: tcell.ColorRed,
-			Body:      ui.PlainString("Connection lost"),
-		})
-		if app.win.ShouldExit() {
-			break
+	}
+	app.events <- event{
+		src:     netID,
+		content: nil,
+	}
+	app.queueStatusLine(netID, ui.Line{
+		Head:      "!!",
+		HeadColor: app.cfg.Theme.Error,
+		Body:      ui.PlainString("Connection lost"),
+	})
+}
+
+// waitRetry waits until the next connection attempt, or until loop is woken
+// up.  It returns false if loop.stop has been closed.
+func (app *App) waitRetry(netID string, loop *netLoop, b *backoff) bool {
+	var timer <-chan time.Time
+	var at time.Time
+	if !loop.isPaused() {
+		d := b.next()
+		timer = time.After(d)
+		at = time.Now().Add(d)
+	}
+	app.events <- event{
+		src:     netID,
+		content: netRetry{at: at},
+	}
+	select {
+	case <-loop.stop:
+		return false
+	case <-loop.wake:
+	case <-timer:
+	}
+	app.events <- event{
+		src:     netID,
+		content: netRetry{},
+	}
+	return true
+}
+
+// version returns the reply to CTCP VERSION requests.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" || info.Main.Version == "(devel)" {
+		return "senpai"
+	}
+	return "senpai " + info.Main.Version
+}
+
+// saslClients returns the SASL mechanisms to authenticate with, in order of
+// preference.
+func saslClients(network ConfigNetwork) []irc.SASLClient {
+	mechs := network.SASLMechanisms
+	if mechs == nil {
+		if network.TLSCertFile != "" {
+			mechs = []string{"EXTERNAL"}
+		} else if network.Password != nil {
+			mechs = []string{"PLAIN"}
+		}
+	}
+	var auths []irc.SASLClient
+	for _, mech := range mechs {
+		switch mech {
+		case "EXTERNAL":
+			auths = append(auths, &irc.SASLExternal{})
+		case "PLAIN":
+			auths = append(auths, &irc.SASLPlain{
+				Username: network.User,
+				Password: *network.Password,
+			})
+		case "SCRAM-SHA-256":
+			auths = append(auths, &irc.SASLScramSHA256{
+				Username: network.User,
+				Password: *network.Password,
+			})
 		}
-		time.Sleep(10 * time.Second)
 	}
+	return auths
 }
 
-func (app *App) connect(netID string) net.Conn {
-	for {
+// connect tries to connect to the server of network, and returns nil if it
+// failed or if stop has been closed.
+func (app *App) connect(netID string, network ConfigNetwork, stop <-chan struct{}) net.Conn {
+	network, upgraded := app.applySTS(network)
+	if upgraded {
 		app.queueStatusLine(netID, ui.Line{
 			Head: "--",
-			Body: ui.PlainSprintf("Connecting to %s...", app.cfg.Addr),
+			Body: ui.PlainString("Using TLS, as required by the STS policy of the server"),
 		})
-		conn, err := app.tryConnect()
-		if err == nil {
-			return conn
-		}
+	}
+	app.queueStatusLine(netID, ui.Line{
+		Head: "--",
+		Body: ui.PlainSprintf("Connecting to %s...", network.Addr),
+	})
+	conn, err := app.tryConnect(netID, network, stop)
+	if err != nil {
 		app.queueStatusLine(netID, ui.Line{
 			Head:      "!!",
-			HeadColor: tcell.ColorRed,
+			HeadColor: app.cfg.Theme.Error,
 			Body:      ui.PlainSprintf("Connection failed: %v", err),
 		})
-		time.Sleep(1 * time.Minute)
+		return nil
 	}
+	return conn
 }
 
-func (app *App) tryConnect() (conn net.Conn, err error) {
-	addr := app.cfg.Addr
-	colonIdx := strings.LastIndexByte(addr, ':')
-	bracketIdx := strings.LastIndexByte(addr, ']')
-	if colonIdx <= bracketIdx {
-		// either colonIdx < 0, or the last colon is before a ']' (end
-		// of IPv6 address. -> missing port
-		if app.cfg.TLS {
-			addr += ":6697"
-		} else {
-			addr += ":6667"
-		}
-	}
+func (app *App) tryConnect(netID string, network ConfigNetwork, stop <-chan struct{}) (conn net.Conn, err error) {
+	addr := addrWithPort(network)
 
-	conn, err = proxy.FromEnvironment().Dial("tcp", addr)
+	dialer, err := networkDialer(network)
+	if err != nil {
+		return nil, err
+	}
+	conn, err = dialer.Dial("tcp", addr)
 	if err != nil {
 		return
 	}
@@ -372,12 +677,28 @@ func (app *App) tryConnect() (conn net.Conn, err error) {
 		tcpConn.SetKeepAlivePeriod(15 * time.Second)
 	}
 
-	if app.cfg.TLS {
+	if network.TLS {
 		host, _, _ := net.SplitHostPort(addr) // should succeed since net.Dial did.
-		conn = tls.Client(conn, &tls.Config{
+		tlsConfig := &tls.Config{
 			ServerName: host,
 			NextProtos: []string{"irc"},
-		})
+			// Certificates are verified by app.verifyCert instead,
+			// which can ask the user, before the client certificate
+			// is sent.
+			InsecureSkipVerify: true,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				return app.verifyCert(netID, addr, host, network, state, stop)
+			},
+		}
+		if network.TLSCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(network.TLSCertFile, network.TLSKeyFile)
+			if err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("failed to load TLS client certificate: %v", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		conn = tls.Client(conn, tlsConfig)
 		err = conn.(*tls.Conn).Handshake()
 		if err != nil {
 			conn.Close()
@@ -421,21 +742,60 @@ func (app *App) handleUIEvent(ev interface{}) bool {
 		app.win.Resize()
 	case *tcell.EventPaste:
 		app.pasting = ev.Start()
+		if ev.End() {
+			app.endPaste()
+		}
 	case *tcell.EventMouse:
 		app.handleMouseEvent(ev)
 	case *tcell.EventKey:
+		app.resetAutoAway()
 		app.handleKeyEvent(ev)
 	case *tcell.EventError:
 		// happens when the terminal is closing: in which case, exit
 		return false
 	case statusLine:
 		app.addStatusLine(ev.netID, ev.line)
+	case refreshTick:
+		app.refreshTimer = nil
+	case *certPrompt:
+		app.certPrompts = append(app.certPrompts, ev)
+		if len(app.certPrompts) == 1 {
+			app.showCertPrompt()
+		}
+	case pasteTick:
+		app.pasteTimer = nil
+		app.sendPasteQueue()
+	case autoAway:
+		for netID, s := range app.sessions {
+			if !s.Away() {
+				s.SetAway(app.cfg.AutoAwayMessage)
+				app.autoAwayNets[netID] = struct{}{}
+			}
+		}
 	default:
 		panic("unreachable")
 	}
 	return true
 }
 
+// autoAway is sent by app.autoAwayTimer.
+type autoAway struct{}
+
+// resetAutoAway restarts the auto-away timer, and removes the away status set
+// by auto-away if any.
+func (app *App) resetAutoAway() {
+	if app.autoAwayTimer == nil {
+		return
+	}
+	app.autoAwayTimer.Reset(app.cfg.AutoAway)
+	for netID := range app.autoAwayNets {
+		if s, ok := app.sessions[netID]; ok && s.Away() {
+			s.SetAway("")
+		}
+		delete(app.autoAwayNets, netID)
+	}
+}
+
 func (app *App) handleMouseEvent(ev *tcell.EventMouse) {
 	x, y := ev.Position()
 	w, h := app.win.Size()
@@ -489,7 +849,7 @@ func (app *App) handleMouseEvent(ev *tcell.EventMouse) {
 						if added {
 							s.MonitorAdd(buffer)
 							s.ReadGet(buffer)
-							s.NewHistoryRequest(buffer).WithLimit(500).Before(time.Now())
+							app.historyBefore(netID, s, buffer, 500, time.Now())
 						}
 					}
 				}
@@ -501,142 +861,31 @@ func (app *App) handleMouseEvent(ev *tcell.EventMouse) {
 }
 
 func (app *App) handleKeyEvent(ev *tcell.EventKey) {
-	switch ev.Key() {
-	case tcell.KeyCtrlC:
-		if app.win.InputClear() {
-			app.typing()
-		} else {
-			app.win.InputSet("/quit")
-		}
-	case tcell.KeyCtrlL:
-		app.win.Resize()
-	case tcell.KeyCtrlU, tcell.KeyPgUp:
-		app.win.ScrollUp()
-		app.requestHistory()
-	case tcell.KeyCtrlD, tcell.KeyPgDn:
-		app.win.ScrollDown()
-	case tcell.KeyCtrlN:
-		app.win.NextBuffer()
-	case tcell.KeyCtrlP:
-		app.win.PreviousBuffer()
-	case tcell.KeyRight:
-		if ev.Modifiers() == tcell.ModAlt {
-			app.win.NextBuffer()
-		} else if ev.Modifiers() == tcell.ModCtrl {
-			app.win.InputRightWord()
-		} else {
-			app.win.InputRight()
-		}
-	case tcell.KeyLeft:
-		if ev.Modifiers() == tcell.ModAlt {
-			app.win.PreviousBuffer()
-		} else if ev.Modifiers() == tcell.ModCtrl {
-			app.win.InputLeftWord()
-		} else {
-			app.win.InputLeft()
-		}
-	case tcell.KeyUp:
-		if ev.Modifiers() == tcell.ModAlt {
-			app.win.PreviousBuffer()
-		} else {
-			app.win.InputUp()
-		}
-	case tcell.KeyDown:
-		if ev.Modifiers() == tcell.ModAlt {
-			app.win.NextBuffer()
-		} else {
-			app.win.InputDown()
-		}
-	case tcell.KeyHome:
-		if ev.Modifiers() == tcell.ModAlt {
-			app.win.GoToBufferNo(0)
-		} else {
-			app.win.InputHome()
-		}
-	case tcell.KeyEnd:
-		if ev.Modifiers() == tcell.ModAlt {
-			maxInt := int(^uint(0) >> 1)
-			app.win.GoToBufferNo(maxInt)
-		} else {
-			app.win.InputEnd()
-		}
-	case tcell.KeyBackspace, tcell.KeyBackspace2:
-		var ok bool
-		if ev.Modifiers() == tcell.ModAlt {
-			ok = app.win.InputDeleteWord()
-		} else {
-			ok = app.win.InputBackspace()
-		}
-		if ok {
-			app.typing()
-		}
-	case tcell.KeyDelete:
-		ok := app.win.InputDelete()
-		if ok {
-			app.typing()
-		}
-	case tcell.KeyCtrlW:
-		ok := app.win.InputDeleteWord()
-		if ok {
-			app.typing()
-		}
-	case tcell.KeyCtrlR:
-		app.win.InputBackSearch()
-	case tcell.KeyTab:
-		ok := app.win.InputAutoComplete(1)
-		if ok {
-			app.typing()
-		}
-	case tcell.KeyBacktab:
-		ok := app.win.InputAutoComplete(-1)
-		if ok {
-			app.typing()
-		}
-	case tcell.KeyEscape:
-		app.win.CloseOverlay()
-	case tcell.KeyF7:
-		app.win.ToggleChannelList()
-	case tcell.KeyF8:
-		app.win.ToggleMemberList()
-	case tcell.KeyCR, tcell.KeyLF:
-		netID, buffer := app.win.CurrentBuffer()
-		input := app.win.InputEnter()
-		err := app.handleInput(buffer, input)
-		if err != nil {
-			app.win.AddLine(netID, buffer, ui.NotifyUnread, ui.Line{
-				At:        time.Now(),
-				Head:      "!!",
-				HeadColor: tcell.ColorRed,
-				Body:      ui.PlainSprintf("%q: %s", input, err),
-			})
-		}
-	case tcell.KeyRune:
-		if ev.Modifiers() == tcell.ModAlt {
-			switch ev.Rune() {
-			case 'n':
-				app.win.ScrollDownHighlight()
-			case 'p':
-				app.win.ScrollUpHighlight()
-			case '1', '2', '3', '4', '5', '6', '7', '8', '9':
-				app.win.GoToBufferNo(int(ev.Rune()-'0') - 1)
-			case 'a':
-				cur := app.win.CurrentBufferID()
-				if app.win.GoToNextUnread() {
-					if app.bufferBeforeCyclingUnread == -1 {
-						app.bufferBeforeCyclingUnread = cur
-					}
-				} else {
-					app.win.GoToBufferNo(app.bufferBeforeCyclingUnread)
-					app.bufferBeforeCyclingUnread = -1
-				}
-			}
-		} else {
-			app.win.InputRune(ev.Rune())
-			app.typing()
+	if len(app.certPrompts) != 0 {
+		app.handleCertPromptKey(ev)
+		return
+	}
+	if app.pasteConfirm != nil {
+		if app.win.HasOverlay() {
+			app.handlePasteConfirmKey(ev)
+			return
 		}
-	default:
+		// The confirmation has been closed by other means.
+		app.pasteConfirm = nil
+	}
+	if app.pasting && (ev.Key() == tcell.KeyCR || ev.Key() == tcell.KeyLF) {
+		// Keep pasted newlines, so that the paste is sent as one
+		// multiline message.
+		app.win.InputRune('\n')
 		return
 	}
+	if app.handleBinding(ev) {
+		return
+	}
+	if ev.Key() == tcell.KeyRune && ev.Modifiers()&tcell.ModAlt == 0 {
+		app.win.InputRune(ev.Rune())
+		app.typing()
+	}
 }
 
 // requestHistory is a wrapper around irc.Session.RequestHistory to only request
@@ -655,9 +904,7 @@ func (app *App) requestHistory() {
 		if bound, ok := app.messageBounds[boundKey{netID, buffer}]; ok {
 			t = bound.first
 		}
-		s.NewHistoryRequest(buffer).
-			WithLimit(200).
-			Before(t)
+		app.historyBefore(netID, s, buffer, 200, t)
 	}
 }
 
@@ -667,9 +914,22 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			s.Close()
 			delete(app.sessions, netID)
 		}
+		app.setNetworkState(netID)
+		return
+	}
+	if r, ok := ev.(netRetry); ok {
+		if loop, ok := app.netLoops[netID]; ok {
+			loop.retryAt = r.at
+		}
+		app.win.SetNetworkRetry(netID, r.at)
 		return
 	}
 	if s, ok := ev.(*irc.Session); ok {
+		if _, ok := app.netLoops[netID]; !ok {
+			// The network has been removed in the meantime.
+			s.Close()
+			return
+		}
 		if s, ok := app.sessions[netID]; ok {
 			s.Close()
 		}
@@ -677,6 +937,7 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		if _, ok := app.monitor[netID]; !ok {
 			app.monitor[netID] = make(map[string]struct{})
 		}
+		app.setNetworkState(netID)
 		return
 	}
 	if _, ok := ev.(irc.Typing); ok {
@@ -690,7 +951,18 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 	}
 	s, ok := app.sessions[netID]
 	if !ok {
-		panic("unreachable")
+		// Messages received before the network was removed.
+		return
+	}
+
+	if msg.Command == "TAGMSG" && msg.Prefix != nil && len(msg.Params) != 0 {
+		channel := ""
+		if s.IsChannel(msg.Params[0]) {
+			channel = msg.Params[0]
+		}
+		if app.isIgnored(netID, channel, msg.Prefix, IgnoreTyping) {
+			return
+		}
 	}
 
 	// Mutate IRC state
@@ -698,7 +970,7 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 	if err != nil {
 		app.win.AddLine(netID, "", ui.NotifyUnread, ui.Line{
 			Head:      "!!",
-			HeadColor: tcell.ColorRed,
+			HeadColor: app.cfg.Theme.Error,
 			Body:      ui.PlainSprintf("Received corrupt message %q: %s", msg.String(), err),
 		})
 		return
@@ -708,10 +980,127 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		app.lastMessageTime = t
 	}
 
+	app.logEvent(netID, s, ev)
+
 	// Mutate UI state
+	ev = app.filterIgnored(netID, s, "", ev)
+	app.handleSessionEvent(netID, s, msg, ev)
+}
+
+// networkNames returns the names netID can be referred to in the
+// configuration: the name of its network block and, for networks of a bouncer,
+// their name on the bouncer.
+func (app *App) networkNames(netID string) []string {
+	networks := []string{app.networks[netID].Name}
+	if bn, ok := app.bouncerNetworks[netID]; ok {
+		networks = append(networks, bn.name)
+	}
+	return networks
+}
+
+// isIgnored returns whether events of the given kind from user, in channel
+// (empty for queries), are hidden by an ignore rule on netID.
+func (app *App) isIgnored(netID, channel string, user *irc.Prefix, kind IgnoreKind) bool {
+	if user == nil || len(app.ignores) == 0 {
+		return false
+	}
+	networks := app.networkNames(netID)
+	for _, ignore := range app.ignores {
+		if ignore.Match(networks, channel, user, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIgnored returns ev without the parts hidden by ignore rules, or nil if
+// it is entirely hidden.  target is the buffer of history messages.
+func (app *App) filterIgnored(netID string, s *irc.Session, target string, ev irc.Event) irc.Event {
+	switch ev := ev.(type) {
+	case irc.MessageEvent:
+		channel := ""
+		if s.IsChannel(ev.Target) {
+			channel = ev.Target
+		}
+		if app.isIgnored(netID, channel, ev.Prefix, IgnoreMessages) {
+			return nil
+		}
+	case irc.CTCPEvent:
+		channel := ""
+		if s.IsChannel(ev.Target) {
+			channel = ev.Target
+		}
+		if app.isIgnored(netID, channel, ev.Prefix, IgnoreMessages) {
+			return nil
+		}
+	case irc.ReactionEvent:
+		channel := ""
+		if ev.TargetIsChannel {
+			channel = ev.Target
+		}
+		if app.isIgnored(netID, channel, ev.Prefix, IgnoreMessages) {
+			return nil
+		}
+	case irc.UserJoinEvent:
+		if app.isIgnored(netID, ev.Channel, ev.Prefix, IgnoreJoins) {
+			return nil
+		}
+	case irc.UserPartEvent:
+		if app.isIgnored(netID, ev.Channel, ev.Prefix, IgnoreJoins) {
+			return nil
+		}
+	case irc.UserQuitEvent:
+		if ev.Channels == nil {
+			// Quits in history are not bound to channels.
+			channel := ""
+			if s.IsChannel(target) {
+				channel = target
+			}
+			if app.isIgnored(netID, channel, ev.Prefix, IgnoreJoins) {
+				return nil
+			}
+			break
+		}
+		channels := make([]string, 0, len(ev.Channels))
+		for _, c := range ev.Channels {
+			if !app.isIgnored(netID, c, ev.Prefix, IgnoreJoins) {
+				channels = append(channels, c)
+			}
+		}
+		ev.Channels = channels
+		return ev
+	case irc.HistoryEvent:
+		messages := make([]irc.Event, 0, len(ev.Messages))
+		for _, m := range ev.Messages {
+			if m := app.filterIgnored(netID, s, ev.Target, m); m != nil {
+				messages = append(messages, m)
+			}
+		}
+		ev.Messages = messages
+		return ev
+	case irc.LabeledEvent:
+		events := make([]irc.Event, 0, len(ev.Events))
+		for _, reply := range ev.Events {
+			if reply := app.filterIgnored(netID, s, target, reply); reply != nil {
+				events = append(events, reply)
+			}
+		}
+		ev.Events = events
+		return ev
+	}
+	return ev
+}
+
+// handleSessionEvent updates the UI according to ev, returned by the session
+// of netID when handling msg.
+func (app *App) handleSessionEvent(netID string, s *irc.Session, msg irc.Message, ev irc.Event) {
 	switch ev := ev.(type) {
 	case irc.RegisteredEvent:
-		for _, channel := range app.cfg.Channels {
+		if loop, ok := app.netLoops[netID]; ok {
+			notify(loop.registered)
+		}
+		network := app.networks[netID]
+		for _, channel := range network.Channels {
 			// TODO: group JOIN messages
 			// TODO: support autojoining channels with keys
 			s.Join(channel, "")
@@ -720,7 +1109,7 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			WithLimit(1000).
 			Targets(app.lastCloseTime, msg.TimeOrNow())
 		body := "Connected to the server"
-		if s.Nick() != app.cfg.Nick {
+		if s.Nick() != network.Nick {
 			body = fmt.Sprintf("Connected to the server as %s", s.Nick())
 		}
 		app.win.AddLine(netID, "", ui.NotifyNone, ui.Line{
@@ -728,14 +1117,42 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			Head: "--",
 			Body: ui.PlainString(body),
 		})
-		for target := range app.monitor[s.NetID()] {
+		for target := range app.monitor[netID] {
 			// TODO: batch MONITOR +
 			s.MonitorAdd(target)
 		}
+		app.setNetworkState(netID)
+	case irc.NickInUseEvent:
+		app.win.AddLine(netID, "", ui.NotifyNone, ui.Line{
+			At:   msg.TimeOrNow(),
+			Head: "--",
+			Body: ui.PlainSprintf("Nickname %s is already in use, trying %s", ev.Nick, ev.Next),
+		})
+	case irc.NickRegainEvent:
+		body := fmt.Sprintf("Nickname %s is in use, waiting for it to be available", ev.Nick)
+		if ev.Available {
+			body = fmt.Sprintf("Nickname %s is available, taking it back", ev.Nick)
+		}
+		app.win.AddLine(netID, "", ui.NotifyNone, ui.Line{
+			At:   msg.TimeOrNow(),
+			Head: "--",
+			Body: ui.PlainString(body),
+		})
+	case irc.SelfAwayEvent:
+		body := "You are no longer marked as being away"
+		if ev.Away {
+			body = "You have been marked as being away"
+		}
+		app.addStatusLine(netID, ui.Line{
+			At:        msg.TimeOrNow(),
+			Head:      "--",
+			HeadColor: app.cfg.Theme.Status,
+			Body:      ui.Styled(body, app.cfg.Theme.StatusStyle()),
+		})
 	case irc.SelfNickEvent:
 		var body ui.StyledStringBuilder
 		body.WriteString(fmt.Sprintf("%s\u2192%s", ev.FormerNick, s.Nick()))
-		textStyle := tcell.StyleDefault.Foreground(tcell.ColorGray)
+		textStyle := app.cfg.Theme.StatusStyle()
 		arrowStyle := tcell.StyleDefault
 		body.AddStyle(0, textStyle)
 		body.AddStyle(len(ev.FormerNick), arrowStyle)
@@ -743,7 +1160,7 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		app.addStatusLine(netID, ui.Line{
 			At:        msg.TimeOrNow(),
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
+			HeadColor: app.cfg.Theme.Status,
 			Body:      body.StyledString(),
 			Highlight: true,
 			Readable:  true,
@@ -757,13 +1174,9 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		i, added := app.win.AddBuffer(netID, "", ev.Channel)
 		bounds, ok := app.messageBounds[boundKey{netID, ev.Channel}]
 		if added || !ok {
-			s.NewHistoryRequest(ev.Channel).
-				WithLimit(500).
-				Before(msg.TimeOrNow())
+			app.historyBefore(netID, s, ev.Channel, 500, msg.TimeOrNow())
 		} else {
-			s.NewHistoryRequest(ev.Channel).
-				WithLimit(1000).
-				After(bounds.last)
+			app.historyAfter(netID, s, ev.Channel, 1000, bounds.last)
 		}
 		if ev.Requested {
 			app.win.JumpBufferIndex(i)
@@ -821,23 +1234,22 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		app.win.AddLine(netID, buffer, notify, ui.Line{
 			At:        msg.TimeOrNow(),
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
-			Body:      ui.Styled(body, tcell.StyleDefault.Foreground(tcell.ColorGray)),
+			HeadColor: app.cfg.Theme.Status,
+			Body:      ui.Styled(body, app.cfg.Theme.StatusStyle()),
 			Highlight: notify == ui.NotifyHighlight,
 			Readable:  true,
 		})
 	case irc.MessageEvent:
-		buffer, line, notification := app.formatMessage(s, ev)
+		buffer, line, notification := app.formatMessage(netID, s, ev)
 		if buffer != "" && !s.IsChannel(buffer) {
 			if _, added := app.win.AddBuffer(netID, "", buffer); added {
 				app.monitor[netID][buffer] = struct{}{}
 				s.MonitorAdd(buffer)
 				s.ReadGet(buffer)
-				s.NewHistoryRequest(buffer).
-					WithLimit(500).
-					Before(msg.TimeOrNow())
+				app.historyBefore(netID, s, buffer, 500, msg.TimeOrNow())
 			}
 		}
+		notification = app.notifyLevel(netID, buffer).Filter(notification)
 		app.win.AddLine(netID, buffer, notification, line)
 		if notification == ui.NotifyHighlight {
 			app.notifyHighlight(buffer, ev.User, line.Body.String())
@@ -883,11 +1295,14 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		var linesBefore []ui.Line
 		var linesAfter []ui.Line
 		bounds, hasBounds := app.messageBounds[boundKey{netID, ev.Target}]
+		var reactions []irc.ReactionEvent
 		for _, m := range ev.Messages {
 			var line ui.Line
 			switch ev := m.(type) {
 			case irc.MessageEvent:
-				_, line, _ = app.formatMessage(s, ev)
+				_, line, _ = app.formatMessage(netID, s, ev)
+			case irc.ReactionEvent:
+				reactions = append(reactions, ev)
 			default:
 				line = app.formatEvent(ev)
 			}
@@ -906,6 +1321,9 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 			}
 		}
 		app.win.AddLines(netID, ev.Target, linesBefore, linesAfter)
+		for _, r := range reactions {
+			app.win.AddReaction(netID, ev.Target, r.ReplyTo, s.Casemap(r.User), r.Reaction)
+		}
 		if len(linesBefore) != 0 {
 			bounds.Update(&linesBefore[0])
 			bounds.Update(&linesBefore[len(linesBefore)-1])
@@ -921,7 +1339,7 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 		app.win.OpenOverlay()
 		lines := make([]ui.Line, 0, len(ev.Messages))
 		for _, m := range ev.Messages {
-			_, line, _ := app.formatMessage(s, m)
+			_, line, _ := app.formatMessage(netID, s, m)
 			if line.IsZero() {
 				continue
 			}
@@ -931,34 +1349,133 @@ func (app *App) handleIRCEvent(netID string, ev interface{}) {
 	case irc.ReadEvent:
 		app.win.SetRead(netID, ev.Target, ev.Timestamp)
 	case irc.BouncerNetworkEvent:
-		_, added := app.win.AddBuffer(ev.ID, ev.Name, "")
+		// Networks of the bouncer behind the top-level connection keep
+		// their ID as netID, others are prefixed by their parent's.
+		childID := ev.ID
+		if netID != "" {
+			childID = netID + "/" + ev.ID
+		}
+		if ev.Deleted {
+			app.removeNetwork(childID)
+			break
+		}
+		app.bouncerNetworks[childID] = bouncerNetwork{
+			parentID: netID,
+			id:       ev.ID,
+			name:     ev.Name,
+			state:    ev.State,
+		}
+		_, added := app.win.AddBuffer(childID, ev.Name, "")
 		if added {
-			go app.ircLoop(ev.ID)
+			app.startNetwork(childID, app.networks[netID], ev.ID)
+		} else {
+			app.setNetworkState(childID)
+		}
+	case irc.LabeledEvent:
+		// Show errors and results in the buffer the request was sent
+		// from.
+		for _, reply := range ev.Events {
+			switch reply := reply.(type) {
+			case irc.ErrorEvent:
+				app.win.AddLine(netID, ev.Origin, ui.NotifyUnread, formatErrorEvent(reply, msg.TimeOrNow()))
+			case irc.WhoisEvent:
+				app.printWhois(netID, ev.Origin, reply, msg.TimeOrNow())
+			default:
+				app.handleSessionEvent(netID, s, msg, reply)
+			}
 		}
-	case irc.ErrorEvent:
-		if isBlackListed(msg.Command) {
+	case irc.STSEvent:
+		network, _ := app.applySTS(app.networks[netID])
+		host, port, err := net.SplitHostPort(addrWithPort(network))
+		if err != nil {
+			break
+		}
+		if !network.TLS {
+			if ev.Port == "" {
+				break
+			}
+			// Policies are only persisted once advertised over TLS.
+			app.setSTSPolicy(host, &STSPolicy{Port: ev.Port})
+			app.addStatusLine(netID, ui.Line{
+				At:        time.Now(),
+				Head:      "--",
+				HeadColor: app.cfg.Theme.Status,
+				Body:      ui.Styled(fmt.Sprintf("The server requires TLS, connecting again on port %s", ev.Port), app.cfg.Theme.StatusStyle()),
+			})
+			if loop, ok := app.netLoops[netID]; ok {
+				notify(loop.wake)
+			}
+			app.closeSession(netID)
+		} else if ev.Duration == 0 {
+			app.setSTSPolicy(host, nil)
+		} else if 0 < ev.Duration {
+			app.setSTSPolicy(host, &STSPolicy{
+				Port:   port,
+				Expiry: time.Now().Add(ev.Duration),
+			})
+		}
+	case irc.ReactionEvent:
+		app.win.AddReaction(netID, reactionBuffer(s, ev), ev.ReplyTo, s.Casemap(ev.User), ev.Reaction)
+	case irc.CTCPEvent:
+		if s.IsMe(ev.User) {
 			break
 		}
-		var head string
 		var body string
-		switch ev.Severity {
-		case irc.SeverityFail:
-			head = "--"
-			body = fmt.Sprintf("Error (code %s): %s", ev.Code, ev.Message)
-		case irc.SeverityWarn:
-			head = "--"
-			body = fmt.Sprintf("Warning (code %s): %s", ev.Code, ev.Message)
-		case irc.SeverityNote:
-			head = ev.Code + " --"
-			body = ev.Message
-		default:
-			panic("unreachable")
+		if ev.Reply {
+			body = fmt.Sprintf("CTCP %s reply from %s", ev.Command, ev.User)
+			key := ctcpKey{netID, s.Casemap(ev.User), ev.Command}
+			if sent, ok := app.ctcpRequests[key]; ok {
+				delete(app.ctcpRequests, key)
+				if time.Since(sent) <= ctcpTimeout {
+					body += fmt.Sprintf(" in %s", time.Since(sent).Round(time.Millisecond))
+				}
+			}
+			if ev.Params != "" && ev.Command != "PING" {
+				body += ": " + ev.Params
+			}
+		} else {
+			body = fmt.Sprintf("%s sent a CTCP %s request", ev.User, ev.Command)
 		}
 		app.addStatusLine(netID, ui.Line{
-			At:   msg.TimeOrNow(),
-			Head: head,
-			Body: ui.PlainString(body),
+			At:        ev.Time,
+			Head:      "--",
+			HeadColor: app.cfg.Theme.Status,
+			Body:      ui.Styled(body, app.cfg.Theme.StatusStyle()),
 		})
+	case irc.WhoisEvent:
+		buffer := ""
+		if curNetID, curBuffer := app.win.CurrentBuffer(); curNetID == netID {
+			buffer = curBuffer
+		}
+		app.printWhois(netID, buffer, ev, msg.TimeOrNow())
+	case irc.ErrorEvent:
+		if isBlackListed(ev.Code) {
+			break
+		}
+		app.addStatusLine(netID, formatErrorEvent(ev, msg.TimeOrNow()))
+	}
+}
+
+func formatErrorEvent(ev irc.ErrorEvent, t time.Time) ui.Line {
+	var head string
+	var body string
+	switch ev.Severity {
+	case irc.SeverityFail:
+		head = "--"
+		body = fmt.Sprintf("Error (code %s): %s", ev.Code, ev.Message)
+	case irc.SeverityWarn:
+		head = "--"
+		body = fmt.Sprintf("Warning (code %s): %s", ev.Code, ev.Message)
+	case irc.SeverityNote:
+		head = ev.Code + " --"
+		body = ev.Message
+	default:
+		panic("unreachable")
+	}
+	return ui.Line{
+		At:   t,
+		Head: head,
+		Body: ui.PlainString(body),
 	}
 }
 
@@ -971,20 +1488,6 @@ func isBlackListed(command string) bool {
 	return false
 }
 
-// isHighlight reports whether the given message content is a highlight.
-func (app *App) isHighlight(s *irc.Session, content string) bool {
-	contentCf := s.Casemap(content)
-	if app.highlights == nil {
-		return strings.Contains(contentCf, s.NickCf())
-	}
-	for _, h := range app.highlights {
-		if strings.Contains(contentCf, s.Casemap(h)) {
-			return true
-		}
-	}
-	return false
-}
-
 // notifyHighlight executes the script at "on-highlight-path" according to the given
 // message context.
 func (app *App) notifyHighlight(buffer, nick, content string) {
@@ -1006,7 +1509,7 @@ func (app *App) notifyHighlight(buffer, nick, content string) {
 			app.addStatusLine(netID, ui.Line{
 				At:        time.Now(),
 				Head:      "!!",
-				HeadColor: tcell.ColorRed,
+				HeadColor: app.cfg.Theme.Error,
 				Body:      ui.PlainString(body),
 			})
 		}
@@ -1029,7 +1532,7 @@ func (app *App) notifyHighlight(buffer, nick, content string) {
 		app.addStatusLine(netID, ui.Line{
 			At:        time.Now(),
 			Head:      "!!",
-			HeadColor: tcell.ColorRed,
+			HeadColor: app.cfg.Theme.Error,
 			Body:      ui.PlainString(body),
 		})
 	}
@@ -1090,7 +1593,7 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 	case irc.UserNickEvent:
 		var body ui.StyledStringBuilder
 		body.WriteString(fmt.Sprintf("%s\u2192%s", ev.FormerNick, ev.User))
-		textStyle := tcell.StyleDefault.Foreground(tcell.ColorGray)
+		textStyle := app.cfg.Theme.StatusStyle()
 		arrowStyle := tcell.StyleDefault
 		body.AddStyle(0, textStyle)
 		body.AddStyle(len(ev.FormerNick), arrowStyle)
@@ -1098,7 +1601,7 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 		return ui.Line{
 			At:        ev.Time,
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
+			HeadColor: app.cfg.Theme.Status,
 			Body:      body.StyledString(),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
@@ -1107,14 +1610,14 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 	case irc.UserJoinEvent:
 		var body ui.StyledStringBuilder
 		body.Grow(len(ev.User) + 1)
-		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGreen))
+		body.SetStyle(tcell.StyleDefault.Foreground(app.cfg.Theme.Join))
 		body.WriteByte('+')
-		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+		body.SetStyle(app.cfg.Theme.StatusStyle())
 		body.WriteString(ev.User)
 		return ui.Line{
 			At:        ev.Time,
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
+			HeadColor: app.cfg.Theme.Status,
 			Body:      body.StyledString(),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
@@ -1123,14 +1626,14 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 	case irc.UserPartEvent:
 		var body ui.StyledStringBuilder
 		body.Grow(len(ev.User) + 1)
-		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorRed))
+		body.SetStyle(tcell.StyleDefault.Foreground(app.cfg.Theme.Part))
 		body.WriteByte('-')
-		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+		body.SetStyle(app.cfg.Theme.StatusStyle())
 		body.WriteString(ev.User)
 		return ui.Line{
 			At:        ev.Time,
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
+			HeadColor: app.cfg.Theme.Status,
 			Body:      body.StyledString(),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
@@ -1139,14 +1642,14 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 	case irc.UserQuitEvent:
 		var body ui.StyledStringBuilder
 		body.Grow(len(ev.User) + 1)
-		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorRed))
+		body.SetStyle(tcell.StyleDefault.Foreground(app.cfg.Theme.Part))
 		body.WriteByte('-')
-		body.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+		body.SetStyle(app.cfg.Theme.StatusStyle())
 		body.WriteString(ev.User)
 		return ui.Line{
 			At:        ev.Time,
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
+			HeadColor: app.cfg.Theme.Status,
 			Body:      body.StyledString(),
 			Mergeable: true,
 			Data:      []irc.Event{ev},
@@ -1158,8 +1661,8 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 		return ui.Line{
 			At:        ev.Time,
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
-			Body:      ui.Styled(body, tcell.StyleDefault.Foreground(tcell.ColorGray)),
+			HeadColor: app.cfg.Theme.Status,
+			Body:      ui.Styled(body, app.cfg.Theme.StatusStyle()),
 			Readable:  true,
 		}
 	case irc.ModeChangeEvent:
@@ -1169,8 +1672,8 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 		return ui.Line{
 			At:        ev.Time,
 			Head:      "--",
-			HeadColor: tcell.ColorGray,
-			Body:      ui.Styled(body, tcell.StyleDefault.Foreground(tcell.ColorGray)),
+			HeadColor: app.cfg.Theme.Status,
+			Body:      ui.Styled(body, app.cfg.Theme.StatusStyle()),
 			Mergeable: mergeable,
 			Data:      []irc.Event{ev},
 			Readable:  true,
@@ -1186,17 +1689,35 @@ func (app *App) formatEvent(ev irc.Event) ui.Line {
 // - which buffer the message must be added to,
 // - the UI line,
 // - what kind of notification senpai should send.
-func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer string, line ui.Line, notification ui.NotifyType) {
+func (app *App) formatMessage(netID string, s *irc.Session, ev irc.MessageEvent) (buffer string, line ui.Line, notification ui.NotifyType) {
 	isFromSelf := s.IsMe(ev.User)
 	isToSelf := s.IsMe(ev.Target)
-	isHighlight := app.isHighlight(s, ev.Content)
 	isAction := strings.HasPrefix(ev.Content, "\x01ACTION")
 	isQuery := !ev.TargetIsChannel && ev.Command == "PRIVMSG"
 	isNotice := ev.Command == "NOTICE"
 
+	content := strings.TrimSuffix(ev.Content, "\x01")
+	content = strings.TrimRightFunc(content, unicode.IsSpace)
+	if isAction {
+		content = content[7:]
+	}
+	text := ui.IRCString(content)
+	channel := ""
+	if ev.TargetIsChannel {
+		channel = ev.Target
+	}
+	var hlSpans [][2]int
+	if !isFromSelf {
+		hlSpans = app.highlightSpans(netID, s, channel, text.String())
+		for _, span := range hlSpans {
+			text = text.Restyle(span[0], span[1], app.cfg.Theme.HighlightStyle)
+		}
+	}
+	isHighlight := len(hlSpans) != 0
+
 	if !ev.TargetIsChannel && isNotice {
 		curNetID, curBuffer := app.win.CurrentBuffer()
-		if curNetID == s.NetID() {
+		if app.sessions[curNetID] == s {
 			buffer = curBuffer
 		} else {
 			isHighlight = true
@@ -1217,39 +1738,34 @@ func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer strin
 	}
 
 	head := ev.User
-	headColor := tcell.ColorWhite
+	headColor := tcell.ColorDefault
 	if isAction || isNotice {
 		head = "*"
 	} else {
 		head = "<" + head + ">"
-		headColor = identColor(head)
+		headColor = app.cfg.Theme.NickColor(ev.User)
 	}
 
-	content := strings.TrimSuffix(ev.Content, "\x01")
-	content = strings.TrimRightFunc(content, unicode.IsSpace)
-	if isAction {
-		content = content[7:]
-	}
 	var body ui.StyledStringBuilder
 	if isNotice {
-		color := identColor(ev.User)
+		color := app.cfg.Theme.NickColor(ev.User)
 		body.SetStyle(tcell.StyleDefault.Foreground(color))
 		body.WriteString(ev.User)
 		body.SetStyle(tcell.StyleDefault)
 		body.WriteString(": ")
-		body.WriteStyledString(ui.IRCString(content))
+		body.WriteStyledString(text)
 	} else if isAction {
-		color := identColor(ev.User)
+		color := app.cfg.Theme.NickColor(ev.User)
 		body.SetStyle(tcell.StyleDefault.Foreground(color))
 		body.WriteString(ev.User)
 		body.SetStyle(tcell.StyleDefault)
-		body.WriteStyledString(ui.IRCString(content))
+		body.WriteStyledString(text)
 	} else {
 		body.SetStyle(tcell.StyleDefault.Foreground(headColor))
 		body.WriteString(head)
 		body.SetStyle(tcell.StyleDefault)
 		body.WriteString(" ")
-		body.WriteStyledString(ui.IRCString(content))
+		body.WriteStyledString(text)
 	}
 
 	line = ui.Line{
@@ -1259,10 +1775,20 @@ func (app *App) formatMessage(s *irc.Session, ev irc.MessageEvent) (buffer strin
 		Body:      body.StyledString(),
 		Highlight: hlLine,
 		Readable:  true,
+		ID:        ev.ID,
+		ReplyTo:   ev.ReplyTo,
 	}
 	return
 }
 
+// reactionBuffer returns the buffer of the message a reaction is sent to.
+func reactionBuffer(s *irc.Session, ev irc.ReactionEvent) string {
+	if !ev.TargetIsChannel && s.IsMe(ev.Target) {
+		return ev.User
+	}
+	return ev.Target
+}
+
 func (app *App) mergeLine(former *ui.Line, addition ui.Line) {
 	events := append(former.Data.([]irc.Event), addition.Data.([]irc.Event)...)
 	type flow struct {
@@ -1374,20 +1900,83 @@ func (app *App) updatePrompt() {
 		prompt = ui.Styled(">",
 			tcell.
 				StyleDefault.
-				Foreground(tcell.Color(app.cfg.Colors.Prompt)),
+				Foreground(tcell.Color(app.cfg.Theme.Prompt)),
 		)
 	} else if s == nil {
 		prompt = ui.Styled("<offline>",
 			tcell.
 				StyleDefault.
-				Foreground(tcell.ColorRed),
+				Foreground(app.cfg.Theme.Error),
+		)
+	} else if s.Away() {
+		prompt = ui.Styled(s.Nick(),
+			tcell.
+				StyleDefault.
+				Foreground(app.cfg.Theme.Status),
 		)
 	} else {
-		prompt = identString(s.Nick())
+		prompt = app.identString(s.Nick())
 	}
 	app.win.SetPrompt(prompt)
 }
 
+// printWhois shows a summary of WHOIS replies in buffer.
+func (app *App) printWhois(netID, buffer string, ev irc.WhoisEvent, t time.Time) {
+	gray := app.cfg.Theme.StatusStyle()
+	addLine := func(body ui.StyledString) {
+		app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+			At:        t,
+			Head:      "--",
+			HeadColor: app.cfg.Theme.Status,
+			Body:      body,
+		})
+	}
+
+	var sb ui.StyledStringBuilder
+	sb.SetStyle(tcell.StyleDefault.Bold(true))
+	sb.WriteString(ev.Nick)
+	sb.SetStyle(gray)
+	if ev.User != "" {
+		sb.WriteString(fmt.Sprintf(" (%s@%s): %s", ev.User, ev.Host, ev.RealName))
+	}
+	addLine(sb.StyledString())
+
+	if ev.Account != "" {
+		addLine(ui.Styled(fmt.Sprintf("  account: %s", ev.Account), gray))
+	} else {
+		addLine(ui.Styled("  not logged in", gray))
+	}
+	if len(ev.Channels) != 0 {
+		sb.Reset()
+		sb.SetStyle(gray)
+		sb.WriteString("  channels:")
+		for _, channel := range ev.Channels {
+			sb.WriteByte(' ')
+			sb.SetStyle(tcell.StyleDefault.Foreground(app.cfg.Theme.PowerLevel))
+			sb.WriteString(channel.PowerLevel)
+			sb.SetStyle(gray)
+			sb.WriteString(channel.Name)
+		}
+		addLine(sb.StyledString())
+	}
+	if ev.Server != "" {
+		addLine(ui.Styled(fmt.Sprintf("  server: %s (%s)", ev.Server, ev.ServerInfo), gray))
+	}
+	if ev.Idle != 0 || !ev.Signon.IsZero() {
+		body := fmt.Sprintf("  idle: %s", ev.Idle)
+		if !ev.Signon.IsZero() {
+			body += fmt.Sprintf(", signed on %s", ev.Signon.Local().Format("Mon Jan 2 15:04:05"))
+		}
+		addLine(ui.Styled(body, gray))
+	}
+	if ev.Operator {
+		addLine(ui.Styled("  is an IRC operator", gray))
+	}
+	if ev.Away != "" {
+		addLine(ui.Styled(fmt.Sprintf("  away: %s", ev.Away), gray))
+	}
+}
+
 func (app *App) printTopic(netID, buffer string) (ok bool) {
 	var body string
 	s := app.sessions[netID]
@@ -1404,8 +1993,8 @@ func (app *App) printTopic(netID, buffer string) (ok bool) {
 	app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 		At:        time.Now(),
 		Head:      "--",
-		HeadColor: tcell.ColorGray,
-		Body:      ui.Styled(body, tcell.StyleDefault.Foreground(tcell.ColorGray)),
+		HeadColor: app.cfg.Theme.Status,
+		Body:      ui.Styled(body, app.cfg.Theme.StatusStyle()),
 	})
 	return true
 }
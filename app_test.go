@@ -0,0 +1,26 @@
+package senpai
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddCTCPRequest(t *testing.T) {
+	app := &App{ctcpRequests: map[ctcpKey]time.Time{}}
+	now := time.Now()
+	stale := ctcpKey{"0", "alice", "VERSION"}
+	pending := ctcpKey{"0", "bob", "PING"}
+	app.addCTCPRequest(stale, now.Add(-ctcpTimeout-time.Second))
+	app.addCTCPRequest(pending, now.Add(-time.Minute))
+
+	key := ctcpKey{"0", "carol", "TIME"}
+	app.addCTCPRequest(key, now)
+	if _, ok := app.ctcpRequests[stale]; ok {
+		t.Errorf("stale request %v was not removed", stale)
+	}
+	for _, k := range []ctcpKey{pending, key} {
+		if _, ok := app.ctcpRequests[k]; !ok {
+			t.Errorf("request %v was removed", k)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package senpai
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff computes the delays between connection attempts, which double after
+// each failure, from min up to max.  Delays are randomized, so that clients
+// disconnected at once do not all come back at the same time.
+type backoff struct {
+	min time.Duration
+	max time.Duration
+	n   int // number of attempts since the last reset.
+}
+
+// next returns the delay before the next attempt, between half and all of the
+// current backoff.
+func (b *backoff) next() time.Duration {
+	d := b.min
+	for i := 0; i < b.n && d < b.max; i++ {
+		d *= 2
+	}
+	if b.max < d {
+		d = b.max
+	}
+	b.n++
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// reset makes the next delay the shortest again, after a successful
+// connection.
+func (b *backoff) reset() {
+	b.n = 0
+}
@@ -0,0 +1,28 @@
+package senpai
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{min: time.Second, max: time.Minute}
+	for _, max := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+		32 * time.Second,
+		time.Minute,
+		time.Minute,
+	} {
+		if d := b.next(); d < max/2 || max < d {
+			t.Errorf("expected a delay between %v and %v, got %v", max/2, max, d)
+		}
+	}
+	b.reset()
+	if d := b.next(); time.Second < d {
+		t.Errorf("expected a delay of at most 1s after reset, got %v", d)
+	}
+}
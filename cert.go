@@ -0,0 +1,233 @@
+package senpai
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.sr.ht/~taiite/senpai/ui"
+	"github.com/gdamore/tcell/v2"
+)
+
+var errCertRejected = errors.New("certificate rejected")
+
+// certFingerprint returns the SHA-256 fingerprint of cert, in lowercase
+// hexadecimal.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint returns fp in lowercase hexadecimal, without the colons
+// some tools put between bytes.
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+}
+
+// certPrompt is a certificate that could not be verified, waiting for the user
+// to accept or reject it.
+type certPrompt struct {
+	netID   string
+	addr    string
+	cert    *x509.Certificate
+	err     error       // why the certificate could not be verified.
+	changed bool        // whether another certificate was trusted before.
+	reply   chan<- bool // receives whether the certificate is accepted.
+}
+
+// verifyCert checks the certificate of the TLS connection to addr, whose host
+// is host.  It accepts certificates that match network.TLSFingerprint if set
+// or, otherwise, those verified by the system roots for host and those trusted
+// before.  Otherwise, the user is asked whether to trust it, until stop is
+// closed, unless host has an STS policy, which forbids it.
+func (app *App) verifyCert(netID, addr, host string, network ConfigNetwork, state tls.ConnectionState, stop <-chan struct{}) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no certificate")
+	}
+	cert := state.PeerCertificates[0]
+	fp := certFingerprint(cert)
+
+	if network.TLSFingerprint != "" {
+		if fp != network.TLSFingerprint {
+			return fmt.Errorf("the certificate fingerprint is %s, not the one of tls-fingerprint", fp)
+		}
+		return nil
+	}
+
+	opts := x509.VerifyOptions{
+		// Not state.ServerName, which is empty for IP addresses.
+		DNSName:       host,
+		Roots:         app.certRoots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(opts)
+	if err == nil {
+		return nil
+	}
+	if _, ok := app.stsPolicy(host); ok {
+		return fmt.Errorf("%v, and the STS policy of %s forbids trusting it anyway", err, host)
+	}
+
+	app.certsMu.Lock()
+	trusted, known := app.trustedCerts[addr]
+	app.certsMu.Unlock()
+	if known && trusted == fp {
+		return nil
+	}
+	if !known && network.TLSTOFU {
+		app.queueStatusLine(netID, ui.Line{
+			Head: "--",
+			Body: ui.PlainSprintf("Trusting the certificate of %s on first use (SHA-256 fingerprint %s)", addr, fp),
+		})
+		app.trustCert(addr, fp)
+		return nil
+	}
+
+	reply := make(chan bool, 1)
+	app.events <- event{
+		src: "*",
+		content: &certPrompt{
+			netID:   netID,
+			addr:    addr,
+			cert:    cert,
+			err:     err,
+			changed: known,
+			reply:   reply,
+		},
+	}
+	select {
+	case accepted := <-reply:
+		if !accepted {
+			return errCertRejected
+		}
+		app.trustCert(addr, fp)
+		return nil
+	case <-stop:
+		return errCertRejected
+	}
+}
+
+func (app *App) trustCert(addr, fp string) {
+	app.certsMu.Lock()
+	defer app.certsMu.Unlock()
+	app.trustedCerts[addr] = fp
+}
+
+// SetTrustedCerts sets the SHA-256 fingerprints of the certificates trusted
+// in a previous run, by server address.
+func (app *App) SetTrustedCerts(certs map[string]string) {
+	app.certsMu.Lock()
+	defer app.certsMu.Unlock()
+	for addr, fp := range certs {
+		app.trustedCerts[addr] = fp
+	}
+}
+
+// TrustedCerts returns the SHA-256 fingerprints of the certificates trusted
+// by the user, by server address.
+func (app *App) TrustedCerts() map[string]string {
+	app.certsMu.Lock()
+	defer app.certsMu.Unlock()
+	certs := make(map[string]string, len(app.trustedCerts))
+	for addr, fp := range app.trustedCerts {
+		certs[addr] = fp
+	}
+	return certs
+}
+
+// showCertPrompt asks the user about the first certificate of
+// app.certPrompts.
+func (app *App) showCertPrompt() {
+	if len(app.certPrompts) == 0 {
+		return
+	}
+	p := app.certPrompts[0]
+	app.win.OpenOverlay()
+
+	gray := app.cfg.Theme.StatusStyle()
+	now := time.Now()
+	var lines []ui.Line
+	addLine := func(head string, headColor tcell.Color, body ui.StyledString) {
+		lines = append(lines, ui.Line{
+			At:        now,
+			Head:      head,
+			HeadColor: headColor,
+			Body:      body,
+		})
+	}
+	if p.changed {
+		addLine("!!", app.cfg.Theme.Error, ui.PlainSprintf("The certificate of %s has changed since you trusted it!", p.addr))
+	}
+	addLine("!!", app.cfg.Theme.Error, ui.PlainSprintf("The certificate of %s could not be verified: %v", p.addr, p.err))
+	addLine("--", app.cfg.Theme.Status, ui.PlainSprintf("Subject: %s", p.cert.Subject))
+	addLine("--", app.cfg.Theme.Status, ui.PlainSprintf("Issuer: %s", p.cert.Issuer))
+	if len(p.cert.DNSNames) != 0 {
+		addLine("--", app.cfg.Theme.Status, ui.PlainSprintf("Names: %s", strings.Join(p.cert.DNSNames, ", ")))
+	}
+	addLine("--", app.cfg.Theme.Status, ui.PlainSprintf("Valid from %s to %s", p.cert.NotBefore.Format(time.RFC1123), p.cert.NotAfter.Format(time.RFC1123)))
+	addLine("--", app.cfg.Theme.Status, ui.PlainSprintf("SHA-256 fingerprint: %s", certFingerprint(p.cert)))
+	addLine("--", app.cfg.Theme.Status, ui.Styled("ENTER: trust this certificate from now on, ESCAPE: abort the connection", gray))
+	app.win.AddLines("", ui.Overlay, lines, nil)
+}
+
+// handleCertPromptKey handles a key press while a certificate prompt is shown.
+func (app *App) handleCertPromptKey(ev *tcell.EventKey) {
+	if !app.win.HasOverlay() {
+		// The prompt has been closed by other means.
+		app.answerCertPrompt(false)
+		return
+	}
+	switch ev.Key() {
+	case tcell.KeyCR, tcell.KeyLF:
+		app.answerCertPrompt(true)
+	case tcell.KeyEscape:
+		app.answerCertPrompt(false)
+	}
+}
+
+// answerCertPrompt replies to the shown certificate prompt, and shows the next
+// one.  Rejecting a certificate stops connecting to its network.
+func (app *App) answerCertPrompt(accepted bool) {
+	p := app.certPrompts[0]
+	app.certPrompts = app.certPrompts[1:]
+	app.win.CloseOverlay()
+	p.reply <- accepted
+	if !accepted {
+		if loop, ok := app.netLoops[p.netID]; ok {
+			loop.setPaused(true)
+		}
+		app.addStatusLine(p.netID, ui.Line{
+			At:        time.Now(),
+			Head:      "--",
+			HeadColor: app.cfg.Theme.Status,
+			Body:      ui.Styled("Certificate rejected, use RECONNECT to try again", app.cfg.Theme.StatusStyle()),
+		})
+	}
+	app.showCertPrompt()
+}
+
+// removeCertPrompts drops the certificate prompts of netID, when it is
+// removed.
+func (app *App) removeCertPrompts(netID string) {
+	var prompts []*certPrompt
+	for i, p := range app.certPrompts {
+		if p.netID != netID {
+			prompts = append(prompts, p)
+		} else if i == 0 {
+			app.win.CloseOverlay()
+		}
+	}
+	shown := len(app.certPrompts) != 0 && app.certPrompts[0].netID != netID
+	app.certPrompts = prompts
+	if !shown {
+		app.showCertPrompt()
+	}
+}
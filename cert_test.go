@@ -0,0 +1,179 @@
+package senpai
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func selfSignedCert(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "irc.example.org"},
+		DNSNames:     []string{"irc.example.org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyCert(t *testing.T) {
+	cert := selfSignedCert(t)
+	fp := certFingerprint(cert)
+	state := tls.ConnectionState{
+		ServerName:       "irc.example.org",
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+	const addr = "irc.example.org:6697"
+	app := &App{
+		events:       make(chan event, eventChanSize),
+		trustedCerts: map[string]string{},
+	}
+
+	if err := app.verifyCert("", addr, "irc.example.org", ConfigNetwork{TLSFingerprint: fp}, state, nil); err != nil {
+		t.Errorf("pinned certificate: %v", err)
+	}
+	if err := app.verifyCert("", addr, "irc.example.org", ConfigNetwork{TLSFingerprint: "00"}, state, nil); err == nil {
+		t.Errorf("expected an error with another pinned certificate")
+	}
+
+	if err := app.verifyCert("", addr, "irc.example.org", ConfigNetwork{TLSTOFU: true}, state, nil); err != nil {
+		t.Errorf("first use: %v", err)
+	}
+	if app.trustedCerts[addr] != fp {
+		t.Errorf("expected the certificate to be trusted after first use")
+	}
+	if err := app.verifyCert("", addr, "irc.example.org", ConfigNetwork{}, state, nil); err != nil {
+		t.Errorf("trusted certificate: %v", err)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	app.trustedCerts[addr] = "00"
+	if err := app.verifyCert("", addr, "irc.example.org", ConfigNetwork{TLSTOFU: true}, state, stop); err != errCertRejected {
+		t.Errorf("changed certificate: expected the user to be asked, got %v", err)
+	}
+	var prompt *certPrompt
+	for len(app.events) != 0 {
+		if p, ok := (<-app.events).content.(*certPrompt); ok {
+			prompt = p
+		}
+	}
+	if prompt == nil || !prompt.changed {
+		t.Errorf("expected a prompt about a changed certificate, got %#v", prompt)
+	}
+}
+
+func TestVerifyCertIPAddress(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Example CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "irc.example.org"},
+		DNSNames:     []string{"irc.example.org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{
+		events:       make(chan event, eventChanSize),
+		trustedCerts: map[string]string{},
+		certRoots:    x509.NewCertPool(),
+	}
+	app.certRoots.AddCert(ca)
+	// Clients send no SNI, and thus have no server name, for IP addresses.
+	state := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+
+	if err := app.verifyCert("", "irc.example.org:6697", "irc.example.org", ConfigNetwork{}, state, nil); err != nil {
+		t.Errorf("valid certificate: %v", err)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	if err := app.verifyCert("", "192.0.2.1:6697", "192.0.2.1", ConfigNetwork{}, state, stop); err != errCertRejected {
+		t.Errorf("certificate of another name: expected the user to be asked, got %v", err)
+	}
+}
+
+func TestVerifyCertSTS(t *testing.T) {
+	cert := selfSignedCert(t)
+	fp := certFingerprint(cert)
+	state := tls.ConnectionState{
+		ServerName:       "irc.example.org",
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+	const addr = "irc.example.org:6697"
+	app := &App{
+		events:       make(chan event, eventChanSize),
+		trustedCerts: map[string]string{addr: fp},
+		stsPolicies: map[string]STSPolicy{
+			"irc.example.org": {Port: "6697"},
+		},
+	}
+
+	if err := app.verifyCert("", addr, "irc.example.org", ConfigNetwork{TLSFingerprint: fp}, state, nil); err != nil {
+		t.Errorf("pinned certificate: %v", err)
+	}
+	if err := app.verifyCert("", addr, "irc.example.org", ConfigNetwork{}, state, nil); err == nil {
+		t.Errorf("trusted certificate: expected an error")
+	}
+	if err := app.verifyCert("", "irc.example.org:6698", "irc.example.org", ConfigNetwork{TLSTOFU: true}, state, nil); err == nil {
+		t.Errorf("unknown certificate: expected an error")
+	}
+	if len(app.events) != 0 {
+		t.Errorf("expected no prompt, got %d events", len(app.events))
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"git.sr.ht/~taiite/senpai"
+	"git.sr.ht/~taiite/senpai/ui"
 	"github.com/gdamore/tcell/v2"
 )
 
@@ -39,6 +40,9 @@ func main() {
 	}
 
 	cfg.Debug = cfg.Debug || debug
+	if cfg.LogFormat != "" {
+		cfg.LogDir = path.Join(cachePath(), "logs")
+	}
 
 	app, err := senpai.NewApp(cfg)
 	if err != nil {
@@ -47,7 +51,10 @@ func main() {
 
 	lastNetID, lastBuffer := getLastBuffer()
 	app.SwitchToBuffer(lastNetID, lastBuffer)
+	app.SetNotifyLevels(getNotifyLevels())
 	app.SetLastClose(getLastStamp())
+	app.SetSTSPolicies(getSTSPolicies())
+	app.SetTrustedCerts(getTrustedCerts())
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
@@ -60,7 +67,10 @@ func main() {
 	app.Run()
 	app.Close()
 	writeLastBuffer(app)
+	writeNotifyLevels(app)
 	writeLastStamp(app)
+	writeSTSPolicies(app)
+	writeTrustedCerts(app)
 }
 
 func cachePath() string {
@@ -103,6 +113,49 @@ func writeLastBuffer(app *senpai.App) {
 	}
 }
 
+func notifyLevelsPath() string {
+	return path.Join(cachePath(), "notify.txt")
+}
+
+func getNotifyLevels() map[string]map[string]ui.NotifyLevel {
+	levels := map[string]map[string]ui.NotifyLevel{}
+	buf, err := ioutil.ReadFile(notifyLevelsPath())
+	if err != nil {
+		return levels
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		level, err := senpai.ParseNotifyLevel(fields[0])
+		if err != nil {
+			continue
+		}
+		netID, buffer := fields[1], fields[2]
+		if levels[netID] == nil {
+			levels[netID] = map[string]ui.NotifyLevel{}
+		}
+		levels[netID][buffer] = level
+	}
+	return levels
+}
+
+func writeNotifyLevels(app *senpai.App) {
+	notifyLevelsPath := notifyLevelsPath()
+	var sb strings.Builder
+	for netID, buffers := range app.NotifyLevels() {
+		for buffer, level := range buffers {
+			fmt.Fprintf(&sb, "%s %s %s\n", level, netID, buffer)
+		}
+	}
+	err := os.WriteFile(notifyLevelsPath, []byte(sb.String()), 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write notification levels at %q: %s\n", notifyLevelsPath, err)
+	}
+}
+
 func lastStampPath() string {
 	return path.Join(cachePath(), "laststamp.txt")
 }
@@ -131,3 +184,76 @@ func writeLastStamp(app *senpai.App) {
 		fmt.Fprintf(os.Stderr, "failed to write last stamp at %q: %s\n", lastStampPath, err)
 	}
 }
+
+func stsPoliciesPath() string {
+	return path.Join(cachePath(), "sts.txt")
+}
+
+func getSTSPolicies() map[string]senpai.STSPolicy {
+	policies := map[string]senpai.STSPolicy{}
+	buf, err := ioutil.ReadFile(stsPoliciesPath())
+	if err != nil {
+		return policies
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		expiry, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			continue
+		}
+		policies[fields[0]] = senpai.STSPolicy{
+			Port:   fields[1],
+			Expiry: expiry,
+		}
+	}
+	return policies
+}
+
+func writeSTSPolicies(app *senpai.App) {
+	stsPoliciesPath := stsPoliciesPath()
+	var sb strings.Builder
+	for host, policy := range app.STSPolicies() {
+		fmt.Fprintf(&sb, "%s %s %s\n", host, policy.Port, policy.Expiry.Format(time.RFC3339))
+	}
+	err := os.WriteFile(stsPoliciesPath, []byte(sb.String()), 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write STS policies at %q: %s\n", stsPoliciesPath, err)
+	}
+}
+
+func trustedCertsPath() string {
+	return path.Join(cachePath(), "fingerprints.txt")
+}
+
+func getTrustedCerts() map[string]string {
+	certs := map[string]string{}
+	buf, err := ioutil.ReadFile(trustedCertsPath())
+	if err != nil {
+		return certs
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		certs[fields[0]] = fields[1]
+	}
+	return certs
+}
+
+func writeTrustedCerts(app *senpai.App) {
+	trustedCertsPath := trustedCertsPath()
+	var sb strings.Builder
+	for addr, fp := range app.TrustedCerts() {
+		fmt.Fprintf(&sb, "%s %s\n", addr, fp)
+	}
+	err := os.WriteFile(trustedCertsPath, []byte(sb.String()), 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write trusted certificates at %q: %s\n", trustedCertsPath, err)
+	}
+}
@@ -57,7 +57,7 @@ func main() {
 		auth = &irc.SASLPlain{Username: nick, Password: password}
 	}
 
-	in, out := irc.ChanInOut(conn)
+	in, out, _ := irc.ChanInOut(conn, irc.FloodControl{})
 	debugOut := make(chan irc.Message, 64)
 	go func() {
 		for msg := range debugOut {
@@ -24,6 +24,7 @@ type command struct {
 	Usage     string
 	Desc      string
 	Handle    func(app *App, args []string) error
+	Alias     string // commands run by user-defined aliases, instead of Handle.
 }
 
 type commandSet map[string]*command
@@ -67,6 +68,50 @@ func init() {
 			Desc:   "show the member list of the current channel",
 			Handle: commandDoNames,
 		},
+		"IGNORE": {
+			AllowHome: true,
+			MaxArgs:   2,
+			Usage:     "[mask] [messages|joins|typing...]",
+			Desc:      "hide events from users matching a mask, or list ignore rules",
+			Handle:    commandDoIgnore,
+		},
+		"UNIGNORE": {
+			AllowHome: true,
+			MinArgs:   1,
+			MaxArgs:   1,
+			Usage:     "<mask>",
+			Desc:      "remove the ignore rules of a mask",
+			Handle:    commandDoUnignore,
+		},
+		"NOTIFY": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[all|highlight|none]",
+			Desc:      "set which messages of the current buffer notify you, or show it",
+			Handle:    commandDoNotify,
+		},
+		"NETWORK": {
+			AllowHome: true,
+			MinArgs:   1,
+			MaxArgs:   2,
+			Usage:     "add|change|del|list [network] [attribute=value...]",
+			Desc:      "manage the networks of the bouncer",
+			Handle:    commandDoNetwork,
+		},
+		"RECONNECT": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[network]",
+			Desc:      "connect again to a network, now",
+			Handle:    commandDoReconnect,
+		},
+		"DISCONNECT": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[network]",
+			Desc:      "disconnect from a network until RECONNECT",
+			Handle:    commandDoDisconnect,
+		},
 		"NICK": {
 			AllowHome: true,
 			MinArgs:   1,
@@ -121,12 +166,33 @@ func init() {
 			Desc:      "reply to the last query",
 			Handle:    commandDoR,
 		},
+		"ANSWER": {
+			MinArgs: 1,
+			MaxArgs: 1,
+			Usage:   "<message>",
+			Desc:    "reply to the selected message",
+			Handle:  commandDoAnswer,
+		},
+		"EMOJI": {
+			MinArgs: 1,
+			MaxArgs: 1,
+			Usage:   "<reaction>",
+			Desc:    "react to the selected message",
+			Handle:  commandDoEmoji,
+		},
 		"TOPIC": {
 			MaxArgs: 1,
 			Usage:   "[topic]",
 			Desc:    "show or set the topic of the current channel",
 			Handle:  commandDoTopic,
 		},
+		"AWAY": {
+			AllowHome: true,
+			MaxArgs:   1,
+			Usage:     "[message]",
+			Desc:      "mark yourself as away, or as back without a message",
+			Handle:    commandDoAway,
+		},
 		"BUFFER": {
 			AllowHome: true,
 			MinArgs:   1,
@@ -167,6 +233,22 @@ func init() {
 			Desc:      "remove effect of a ban from the user",
 			Handle:    commandDoUnban,
 		},
+		"WHOIS": {
+			AllowHome: true,
+			MinArgs:   1,
+			MaxArgs:   1,
+			Usage:     "<nick>",
+			Desc:      "show information about someone",
+			Handle:    commandDoWhois,
+		},
+		"CTCP": {
+			AllowHome: true,
+			MinArgs:   2,
+			MaxArgs:   2,
+			Usage:     "<nick> <command> [params]",
+			Desc:      "send a CTCP request, such as VERSION or PING",
+			Handle:    commandDoCTCP,
+		},
 		"SEARCH": {
 			AllowHome: true,
 			MaxArgs:   1,
@@ -179,6 +261,12 @@ func init() {
 
 func noCommand(app *App, content string) error {
 	netID, buffer := app.win.CurrentBuffer()
+	return sendMessage(app, netID, buffer, content)
+}
+
+// sendMessage sends content to buffer, and shows it if the server does not
+// echo messages.
+func sendMessage(app *App, netID, buffer, content string) error {
 	if buffer == "" {
 		return fmt.Errorf("can't send message to this buffer")
 	}
@@ -189,17 +277,91 @@ func noCommand(app *App, content string) error {
 
 	s.PrivMsg(buffer, content)
 	if !s.HasCapability("echo-message") {
-		buffer, line, _ := app.formatMessage(s, irc.MessageEvent{
+		ev := irc.MessageEvent{
 			User:            s.Nick(),
 			Target:          buffer,
 			TargetIsChannel: s.IsChannel(buffer),
 			Command:         "PRIVMSG",
 			Content:         content,
 			Time:            time.Now(),
-		})
+		}
+		app.logEvent(netID, s, ev)
+		buffer, line, _ := app.formatMessage(netID, s, ev)
+		app.win.AddLine(netID, buffer, ui.NotifyNone, line)
+	}
+
+	return nil
+}
+
+// selectedMessage returns the session of the current buffer and the msgid of
+// its selected line.
+func selectedMessage(app *App) (s *irc.Session, msgID string, err error) {
+	s = app.CurrentSession()
+	if s == nil {
+		return nil, "", errOffline
+	}
+	if !s.HasCapability("message-tags") {
+		return nil, "", fmt.Errorf("the server does not support message tags")
+	}
+	msgID = app.win.SelectedLine()
+	if msgID == "" {
+		return nil, "", fmt.Errorf("no message selected, select one with CTRL-UP")
+	}
+	return s, msgID, nil
+}
+
+func commandDoAnswer(app *App, args []string) (err error) {
+	s, msgID, err := selectedMessage(app)
+	if err != nil {
+		return err
+	}
+	netID, buffer := app.win.CurrentBuffer()
+	s.Reply(buffer, msgID, args[0])
+	app.win.ClearSelection()
+	if !s.HasCapability("echo-message") {
+		ev := irc.MessageEvent{
+			User:            s.Nick(),
+			Target:          buffer,
+			TargetIsChannel: s.IsChannel(buffer),
+			Command:         "PRIVMSG",
+			Content:         args[0],
+			Time:            time.Now(),
+			ReplyTo:         msgID,
+		}
+		app.logEvent(netID, s, ev)
+		buffer, line, _ := app.formatMessage(netID, s, ev)
 		app.win.AddLine(netID, buffer, ui.NotifyNone, line)
 	}
+	return nil
+}
+
+func commandDoEmoji(app *App, args []string) (err error) {
+	s, msgID, err := selectedMessage(app)
+	if err != nil {
+		return err
+	}
+	netID, buffer := app.win.CurrentBuffer()
+	s.React(buffer, msgID, args[0])
+	app.win.ClearSelection()
+	if !s.HasCapability("echo-message") {
+		app.win.AddReaction(netID, buffer, msgID, s.NickCf(), args[0])
+	}
+	return nil
+}
 
+func commandDoAway(app *App, args []string) (err error) {
+	netID, _ := app.win.CurrentBuffer()
+	s := app.sessions[netID]
+	if s == nil {
+		return errOffline
+	}
+	message := ""
+	if len(args) != 0 {
+		message = args[0]
+	}
+	s.SetAway(message)
+	// Keep the status chosen by the user when keys are pressed again.
+	delete(app.autoAwayNets, netID)
 	return nil
 }
 
@@ -247,7 +409,7 @@ func commandDoHelp(app *App, args []string) (err error) {
 		sort.Strings(names)
 		var sb ui.StyledStringBuilder
 		for _, name := range names {
-			addLineCommand(&sb, name, commands[name])
+			addLineCommand(&sb, name, app.commands[name])
 		}
 	}
 
@@ -258,8 +420,8 @@ func commandDoHelp(app *App, args []string) (err error) {
 			Body: ui.PlainString("Available commands:"),
 		})
 
-		cmdNames := make([]string, 0, len(commands))
-		for cmdName := range commands {
+		cmdNames := make([]string, 0, len(app.commands))
+		for cmdName := range app.commands {
 			cmdNames = append(cmdNames, cmdName)
 		}
 		addLineCommands(cmdNames)
@@ -271,8 +433,8 @@ func commandDoHelp(app *App, args []string) (err error) {
 			Body: ui.PlainSprintf("Commands that match \"%s\":", search),
 		})
 
-		cmdNames := make([]string, 0, len(commands))
-		for cmdName := range commands {
+		cmdNames := make([]string, 0, len(app.commands))
+		for cmdName := range app.commands {
 			if !strings.Contains(cmdName, search) {
 				continue
 			}
@@ -314,18 +476,23 @@ func commandDoMe(app *App, args []string) (err error) {
 	if s == nil {
 		return errOffline
 	}
-	content := fmt.Sprintf("\x01ACTION %s\x01", args[0])
-	s.PrivMsg(buffer, content)
-	if !s.HasCapability("echo-message") {
-		buffer, line, _ := app.formatMessage(s, irc.MessageEvent{
-			User:            s.Nick(),
-			Target:          buffer,
-			TargetIsChannel: s.IsChannel(buffer),
-			Command:         "PRIVMSG",
-			Content:         content,
-			Time:            time.Now(),
-		})
-		app.win.AddLine(netID, buffer, ui.NotifyNone, line)
+	// One action per line, since CTCP messages cannot span several lines.
+	for _, action := range strings.Split(args[0], "\n") {
+		content := fmt.Sprintf("\x01ACTION %s\x01", action)
+		s.PrivMsg(buffer, content)
+		if !s.HasCapability("echo-message") {
+			ev := irc.MessageEvent{
+				User:            s.Nick(),
+				Target:          buffer,
+				TargetIsChannel: s.IsChannel(buffer),
+				Command:         "PRIVMSG",
+				Content:         content,
+				Time:            time.Now(),
+			}
+			app.logEvent(netID, s, ev)
+			buffer, line, _ := app.formatMessage(netID, s, ev)
+			app.win.AddLine(netID, buffer, ui.NotifyNone, line)
+		}
 	}
 	return nil
 }
@@ -346,13 +513,13 @@ func commandDoNames(app *App, args []string) (err error) {
 		return fmt.Errorf("this is not a channel")
 	}
 	var sb ui.StyledStringBuilder
-	sb.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+	sb.SetStyle(app.cfg.Theme.StatusStyle())
 	sb.WriteString("Names: ")
 	for _, name := range s.Names(buffer) {
 		if name.PowerLevel != "" {
-			sb.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGreen))
+			sb.SetStyle(tcell.StyleDefault.Foreground(app.cfg.Theme.PowerLevel))
 			sb.WriteString(name.PowerLevel)
-			sb.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+			sb.SetStyle(app.cfg.Theme.StatusStyle())
 		}
 		sb.WriteString(name.Name.Name)
 		sb.WriteByte(' ')
@@ -362,12 +529,285 @@ func commandDoNames(app *App, args []string) (err error) {
 	app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
 		At:        time.Now(),
 		Head:      "--",
-		HeadColor: tcell.ColorGray,
+		HeadColor: app.cfg.Theme.Status,
 		Body:      body,
 	})
 	return nil
 }
 
+func commandDoIgnore(app *App, args []string) (err error) {
+	if len(args) == 0 {
+		netID, buffer := app.win.CurrentBuffer()
+		t := time.Now()
+		if len(app.ignores) == 0 {
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+				At:        t,
+				Head:      "--",
+				HeadColor: app.cfg.Theme.Status,
+				Body:      ui.PlainString("No ignore rules"),
+			})
+		}
+		for _, ignore := range app.ignores {
+			var sb ui.StyledStringBuilder
+			sb.SetStyle(tcell.StyleDefault.Bold(true))
+			sb.WriteString(ignore.Mask)
+			sb.SetStyle(app.cfg.Theme.StatusStyle())
+			sb.WriteString(": " + ignore.Kinds.String())
+			if ignore.Network != "" {
+				sb.WriteString(" on " + ignore.Network)
+			}
+			if len(ignore.Channels) != 0 {
+				sb.WriteString(" in " + strings.Join(ignore.Channels, ", "))
+			}
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+				At:        t,
+				Head:      "--",
+				HeadColor: app.cfg.Theme.Status,
+				Body:      sb.StyledString(),
+			})
+		}
+		return nil
+	}
+
+	var kindNames []string
+	if len(args) == 2 {
+		kindNames = strings.Fields(args[1])
+	}
+	kinds, err := parseIgnoreKinds(kindNames)
+	if err != nil {
+		return err
+	}
+	app.ignores = append(app.ignores, Ignore{
+		Mask:  normalizeMask(args[0]),
+		Kinds: kinds,
+	})
+	return nil
+}
+
+func commandDoUnignore(app *App, args []string) (err error) {
+	mask := irc.CasemapRFC1459(normalizeMask(args[0]))
+	ignores := app.ignores[:0]
+	for _, ignore := range app.ignores {
+		if irc.CasemapRFC1459(ignore.Mask) != mask {
+			ignores = append(ignores, ignore)
+		}
+	}
+	if len(ignores) == len(app.ignores) {
+		return fmt.Errorf("no ignore rule for %q", args[0])
+	}
+	app.ignores = ignores
+	return nil
+}
+
+func commandDoNotify(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	if len(args) == 0 {
+		level := app.notifyLevel(netID, buffer)
+		app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+			At:        time.Now(),
+			Head:      "--",
+			HeadColor: app.cfg.Theme.Status,
+			Body:      ui.Styled("Notification level: "+level.String(), app.cfg.Theme.StatusStyle()),
+		})
+		return nil
+	}
+
+	level, err := ParseNotifyLevel(args[0])
+	if err != nil {
+		return err
+	}
+	app.setNotifyLevel(netID, buffer, level)
+	return nil
+}
+
+func commandDoNetwork(app *App, args []string) (err error) {
+	netID, buffer := app.win.CurrentBuffer()
+	parentID := netID
+	bn, isBouncerNetwork := app.bouncerNetworks[netID]
+	if isBouncerNetwork {
+		parentID = bn.parentID
+	}
+	s := app.sessions[parentID]
+	if s == nil {
+		return errOffline
+	}
+	if !s.HasCapability("soju.im/bouncer-networks") {
+		return fmt.Errorf("the server does not support bouncer networks")
+	}
+
+	subcommand := strings.ToUpper(args[0])
+	var fields []string
+	if len(args) == 2 {
+		fields = strings.Fields(args[1])
+	}
+	// CHANGE and DEL apply to the network given by ID or name or, without
+	// one, to the network of the current buffer.
+	var id string
+	if isBouncerNetwork {
+		id = bn.id
+	}
+	if (subcommand == "CHANGE" || subcommand == "DEL") && len(fields) != 0 && !strings.Contains(fields[0], "=") {
+		id = ""
+		for _, network := range s.BouncerNetworks() {
+			if network.ID == fields[0] || strings.EqualFold(network.Attrs["name"], fields[0]) {
+				id = network.ID
+				break
+			}
+		}
+		if id == "" {
+			return fmt.Errorf("no network named %q, see NETWORK LIST", fields[0])
+		}
+		fields = fields[1:]
+	}
+
+	attrs := map[string]string{}
+	for _, attr := range fields {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid attribute %q, expected attribute=value", attr)
+		}
+		attrs[kv[0]] = kv[1]
+	}
+
+	switch subcommand {
+	case "ADD":
+		if len(attrs) == 0 {
+			return fmt.Errorf("usage: NETWORK ADD attribute=value...")
+		}
+		s.AddNetwork(attrs)
+	case "CHANGE", "DEL":
+		if id == "" {
+			return fmt.Errorf("this is not a network of the bouncer, give the network to %s", strings.ToLower(subcommand))
+		}
+		if subcommand == "DEL" {
+			s.DeleteNetwork(id)
+		} else if len(attrs) == 0 {
+			return fmt.Errorf("usage: NETWORK CHANGE [network] attribute=value...")
+		} else {
+			s.ChangeNetwork(id, attrs)
+		}
+	case "LIST":
+		t := time.Now()
+		networks := s.BouncerNetworks()
+		if len(networks) == 0 {
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+				At:        t,
+				Head:      "--",
+				HeadColor: app.cfg.Theme.Status,
+				Body:      ui.PlainString("No networks"),
+			})
+		}
+		for _, network := range networks {
+			var sb ui.StyledStringBuilder
+			sb.SetStyle(tcell.StyleDefault.Bold(true))
+			sb.WriteString(network.Attrs["name"])
+			sb.SetStyle(app.cfg.Theme.StatusStyle())
+			sb.WriteString(fmt.Sprintf(" (%s) %s", network.ID, network.Attrs["host"]))
+			if state, ok := network.Attrs["state"]; ok {
+				sb.WriteString(": " + state)
+			}
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+				At:        t,
+				Head:      "--",
+				HeadColor: app.cfg.Theme.Status,
+				Body:      sb.StyledString(),
+			})
+		}
+	default:
+		return fmt.Errorf("unknown subcommand %q, expected add, change, del or list", args[0])
+	}
+	return nil
+}
+
+// findNetwork returns the netID of the network with the given name, or of the
+// current buffer if args is empty.
+func findNetwork(app *App, args []string) (string, error) {
+	if len(args) == 0 {
+		netID, _ := app.win.CurrentBuffer()
+		return netID, nil
+	}
+	for netID := range app.netLoops {
+		// Networks of a bouncer share the configuration of the
+		// connection to the bouncer, and only go by their own name.
+		name := app.networks[netID].Name
+		if bn, ok := app.bouncerNetworks[netID]; ok {
+			name = bn.name
+		}
+		if strings.EqualFold(name, args[0]) {
+			return netID, nil
+		}
+	}
+	return "", fmt.Errorf("no network named %q", args[0])
+}
+
+func commandDoReconnect(app *App, args []string) (err error) {
+	netID, err := findNetwork(app, args)
+	if err != nil {
+		return err
+	}
+	loop, ok := app.netLoops[netID]
+	if !ok {
+		return fmt.Errorf("this buffer has no network to connect to")
+	}
+	loop.setPaused(false)
+	if s, ok := app.sessions[netID]; ok {
+		// The connection loop connects again as soon as the session
+		// ends.
+		s.Quit("Reconnecting")
+		app.closeSession(netID)
+	}
+	return nil
+}
+
+func commandDoDisconnect(app *App, args []string) (err error) {
+	netID, err := findNetwork(app, args)
+	if err != nil {
+		return err
+	}
+	loop, ok := app.netLoops[netID]
+	if !ok {
+		return fmt.Errorf("this buffer has no network to connect to")
+	}
+	if loop.isPaused() {
+		return fmt.Errorf("already disconnected, use RECONNECT to connect again")
+	}
+	loop.setPaused(true)
+	if s, ok := app.sessions[netID]; ok {
+		s.Quit("")
+		app.closeSession(netID)
+	}
+	return nil
+}
+
+func commandDoWhois(app *App, args []string) (err error) {
+	s := app.CurrentSession()
+	if s == nil {
+		return errOffline
+	}
+	s.Whois(args[0])
+	return nil
+}
+
+func commandDoCTCP(app *App, args []string) (err error) {
+	s := app.CurrentSession()
+	if s == nil {
+		return errOffline
+	}
+	netID, _ := app.win.CurrentBuffer()
+	target := args[0]
+	command, params := args[1], ""
+	if i := strings.IndexByte(command, ' '); i >= 0 {
+		command, params = command[:i], command[i+1:]
+	}
+	command = strings.ToUpper(command)
+	if command == "PING" && params == "" {
+		params = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	app.addCTCPRequest(ctcpKey{netID, s.Casemap(target), command}, time.Now())
+	s.CTCP(target, command, params)
+	return nil
+}
+
 func commandDoNick(app *App, args []string) (err error) {
 	nick := args[0]
 	if i := strings.IndexAny(nick, " :"); i >= 0 {
@@ -454,7 +894,7 @@ func commandDoQuery(app *App, args []string) (err error) {
 	if added {
 		s.MonitorAdd(target)
 		s.ReadGet(target)
-		s.NewHistoryRequest(target).WithLimit(200).Before(time.Now())
+		app.historyBefore(netID, s, target, 200, time.Now())
 	}
 	return nil
 }
@@ -487,14 +927,16 @@ func commandDoR(app *App, args []string) (err error) {
 	}
 	s.PrivMsg(app.lastQuery, args[0])
 	if !s.HasCapability("echo-message") {
-		buffer, line, _ := app.formatMessage(s, irc.MessageEvent{
+		ev := irc.MessageEvent{
 			User:            s.Nick(),
 			Target:          app.lastQuery,
 			TargetIsChannel: s.IsChannel(app.lastQuery),
 			Command:         "PRIVMSG",
 			Content:         args[0],
 			Time:            time.Now(),
-		})
+		}
+		app.logEvent(app.lastQueryNet, s, ev)
+		buffer, line, _ := app.formatMessage(app.lastQueryNet, s, ev)
 		app.win.AddLine(app.lastQueryNet, buffer, ui.NotifyNone, line)
 	}
 	return nil
@@ -674,14 +1116,16 @@ func commandSendMessage(app *App, target string, content string) error {
 	}
 	s.PrivMsg(target, content)
 	if !s.HasCapability("echo-message") {
-		buffer, line, _ := app.formatMessage(s, irc.MessageEvent{
+		ev := irc.MessageEvent{
 			User:            s.Nick(),
 			Target:          target,
 			TargetIsChannel: s.IsChannel(target),
 			Command:         "PRIVMSG",
 			Content:         content,
 			Time:            time.Now(),
-		})
+		}
+		app.logEvent(netID, s, ev)
+		buffer, line, _ := app.formatMessage(netID, s, ev)
 		if buffer != "" && !s.IsChannel(target) {
 			app.monitor[netID][buffer] = struct{}{}
 			s.MonitorAdd(buffer)
@@ -695,6 +1139,7 @@ func commandSendMessage(app *App, target string, content string) error {
 }
 
 func (app *App) handleInput(buffer, content string) error {
+	content = strings.TrimRight(content, "\n")
 	if content == "" {
 		return nil
 	}
@@ -706,25 +1151,18 @@ func (app *App) handleInput(buffer, content string) error {
 	if cmdName == "" {
 		return fmt.Errorf("lone slash at the beginning")
 	}
+	if strings.ContainsAny(content, "\r\n") {
+		return fmt.Errorf("commands cannot span several lines")
+	}
 
-	var chosenCMDName string
-	var found bool
-	for key := range commands {
-		if !strings.HasPrefix(key, cmdName) {
-			continue
-		}
-		if found {
-			return fmt.Errorf("ambiguous command %q (could mean %v or %v)", cmdName, chosenCMDName, key)
-		}
-		chosenCMDName = key
-		found = true
+	chosenCMDName, cmd, err := app.findCommand(cmdName)
+	if err != nil {
+		return err
 	}
-	if !found {
-		return fmt.Errorf("command %q doesn't exist", cmdName)
+	if cmd.Alias != "" {
+		return app.runAlias(buffer, chosenCMDName, cmd, rawArgs)
 	}
 
-	cmd := commands[chosenCMDName]
-
 	var args []string
 	if rawArgs != "" && cmd.MaxArgs != 0 {
 		args = fieldsN(rawArgs, cmd.MaxArgs)
@@ -737,5 +1175,14 @@ func (app *App) handleInput(buffer, content string) error {
 		return fmt.Errorf("command %s cannot be executed from a server buffer", chosenCMDName)
 	}
 
-	return cmd.Handle(app, args)
+	s := app.CurrentSession()
+	if s == nil {
+		return cmd.Handle(app, args)
+	}
+	// Replies to the messages sent by the command, errors in particular,
+	// are shown in this buffer.
+	s.Labeled(buffer, func() {
+		err = cmd.Handle(app, args)
+	})
+	return err
 }
@@ -0,0 +1,56 @@
+package senpai
+
+import "testing"
+
+func TestHandleInputPastedCommand(t *testing.T) {
+	app := &App{
+		commands: commandSet{
+			"QUOTE": commands["QUOTE"],
+			"TOPIC": commands["TOPIC"],
+		},
+		expanding: map[string]bool{},
+	}
+	for _, input := range []string{
+		"/topic x\nQUIT :bye",
+		"/quote PRIVMSG #senpai :a\r\nQUIT",
+		"/topic x\n\n/quote QUIT",
+	} {
+		if err := app.handleInput("#senpai", input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestFindNetwork(t *testing.T) {
+	app := &App{
+		networks: map[string]ConfigNetwork{
+			"0":   {Name: "soju"},
+			"0/1": {Name: "soju"},
+			"0/2": {Name: "soju"},
+		},
+		netLoops: map[string]*netLoop{
+			"0":   {},
+			"0/1": {},
+			"0/2": {},
+		},
+		bouncerNetworks: map[string]bouncerNetwork{
+			"0/1": {parentID: "0", id: "1", name: "libera"},
+			"0/2": {parentID: "0", id: "2", name: "oftc"},
+		},
+	}
+	for name, expected := range map[string]string{
+		"soju":   "0",
+		"Libera": "0/1",
+		"oftc":   "0/2",
+	} {
+		// Map order is random, try a few times.
+		for i := 0; i < 10; i++ {
+			if netID, err := findNetwork(app, []string{name}); err != nil || netID != expected {
+				t.Fatalf("%q: expected %q, got %q, %v", name, expected, netID, err)
+			}
+		}
+	}
+	if _, err := findNetwork(app, []string{"1"}); err == nil {
+		t.Errorf("expected no network named 1")
+	}
+}
@@ -1,17 +1,24 @@
 package senpai
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 
 	"git.sr.ht/~emersion/go-scfg"
+
+	"git.sr.ht/~taiite/senpai/irc"
+	"git.sr.ht/~taiite/senpai/ui"
 )
 
 func parseColor(s string, c *tcell.Color) error {
@@ -44,24 +51,78 @@ func parseColor(s string, c *tcell.Color) error {
 	return nil
 }
 
-type ConfigColors struct {
-	Prompt tcell.Color
-	Unread tcell.Color
-}
-
-type Config struct {
+// ConfigNetwork is the configuration of a connection to an IRC server.
+type ConfigNetwork struct {
+	Name     string // name of the network block, empty for the top-level one.
 	Addr     string
 	Nick     string
+	AltNicks []string
 	Real     string
 	User     string
 	Password *string
 	TLS      bool
 	Channels []string
 
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSFingerprint is the SHA-256 fingerprint of the only certificate
+	// accepted, in lowercase hexadecimal, if set.
+	TLSFingerprint string
+	// TLSTOFU is whether to trust certificates that cannot be verified the
+	// first time they are seen, without asking.
+	TLSTOFU bool
+
+	// Proxy is the URL of the proxy to connect through, "direct" to connect
+	// without proxy, or empty to use the proxy of the environment.
+	Proxy string
+
+	// SASLMechanisms are the SASL mechanisms to try, in order.  If nil,
+	// EXTERNAL is used with TLS client certificates and PLAIN with
+	// passwords.
+	SASLMechanisms []string
+}
+
+type Config struct {
+	ConfigNetwork
+
+	// Networks are the servers configured in network blocks, connected to
+	// alongside the top-level one.
+	Networks []ConfigNetwork
+
 	Typings bool
 	Mouse   bool
 
-	Highlights       []string
+	// AutoAway is how long without keyboard input before being marked as
+	// away with AutoAwayMessage, or 0 to disable.
+	AutoAway        time.Duration
+	AutoAwayMessage string
+
+	// PasteLines is the number of lines above which pastes must be
+	// confirmed before being sent, or 0 to never ask.
+	PasteLines int
+
+	// Flood limits the rate of outgoing messages.
+	Flood irc.FloodControl
+
+	// Ignores are the rules hiding events from some users.
+	Ignores []Ignore
+
+	// Notify are the rules setting the notification level of buffers.  The
+	// last matching rule applies.
+	Notify []NotifyRule
+
+	// LogFormat is the format of the local message log, LogText or LogJSON,
+	// or empty to keep no log.  Logs are written in LogDir and kept for
+	// LogKeep days, or forever if 0.
+	LogFormat string
+	LogDir    string
+	LogKeep   int
+
+	// Highlights are the rules deciding which messages highlight the user.
+	// Without rules other than exclusions, the nickname of the user does.
+	Highlights []HighlightRule
+
 	OnHighlightPath  string
 	NickColWidth     int
 	ChanColWidth     int
@@ -69,7 +130,16 @@ type Config struct {
 	MemberColWidth   int
 	MemberColEnabled bool
 
-	Colors ConfigColors
+	// Theme is the set of colors of the interface.
+	Theme ui.Theme
+
+	// Aliases are the commands run by user-defined commands, by uppercase
+	// name, see expandAlias.
+	Aliases map[string]string
+
+	// Bindings are the actions or commands bound to keys, overriding the
+	// default ones.  "none" removes the default binding of a key.
+	Bindings map[Key]string
 
 	Debug bool
 }
@@ -84,15 +154,15 @@ func DefaultHighlightPath() (string, error) {
 
 func Defaults() (cfg Config, err error) {
 	cfg = Config{
-		Addr:             "",
-		Nick:             "",
-		Real:             "",
-		User:             "",
-		Password:         nil,
-		TLS:              true,
-		Channels:         nil,
+		ConfigNetwork:    defaultNetwork(),
+		Networks:         nil,
 		Typings:          true,
+		AutoAway:         0,
+		AutoAwayMessage:  "Auto away",
+		PasteLines:       3,
+		Flood:            irc.FloodControl{Burst: 5, Interval: time.Second},
 		Mouse:            true,
+		Ignores:          nil,
 		Highlights:       nil,
 		OnHighlightPath:  "",
 		NickColWidth:     14,
@@ -100,16 +170,30 @@ func Defaults() (cfg Config, err error) {
 		ChanColEnabled:   true,
 		MemberColWidth:   16,
 		MemberColEnabled: true,
-		Colors: ConfigColors{
-			Prompt: tcell.ColorDefault,
-			Unread: tcell.ColorDefault,
-		},
-		Debug: false,
+		Theme:            ui.DarkTheme(),
+		Debug:            false,
 	}
 
 	return
 }
 
+func defaultNetwork() ConfigNetwork {
+	return ConfigNetwork{
+		Name:           "",
+		Addr:           "",
+		Nick:           "",
+		AltNicks:       nil,
+		Real:           "",
+		User:           "",
+		Password:       nil,
+		TLS:            true,
+		Channels:       nil,
+		TLSCertFile:    "",
+		TLSKeyFile:     "",
+		SASLMechanisms: nil,
+	}
+}
+
 func LoadConfigFile(filename string) (cfg Config, err error) {
 	cfg, err = Defaults()
 	if err != nil {
@@ -120,21 +204,93 @@ func LoadConfigFile(filename string) (cfg Config, err error) {
 	if err != nil {
 		return cfg, err
 	}
-	if cfg.Addr == "" {
+	if cfg.Addr == "" && len(cfg.Networks) == 0 {
 		return cfg, errors.New("addr is required")
 	}
-	if cfg.Nick == "" {
-		return cfg, errors.New("nick is required")
-	}
-	if cfg.User == "" {
-		cfg.User = cfg.Nick
+	// Identity is shared with network blocks, credentials are not.
+	nick, altNicks, user, realName := cfg.Nick, cfg.AltNicks, cfg.User, cfg.Real
+	if cfg.Addr != "" {
+		if err := checkNetwork(&cfg.ConfigNetwork); err != nil {
+			return cfg, err
+		}
 	}
-	if cfg.Real == "" {
-		cfg.Real = cfg.Nick
+	names := map[string]struct{}{}
+	for i := range cfg.Networks {
+		network := &cfg.Networks[i]
+		if _, ok := names[network.Name]; ok {
+			return cfg, fmt.Errorf("duplicate network %q", network.Name)
+		}
+		names[network.Name] = struct{}{}
+
+		if network.Nick == "" {
+			network.Nick = nick
+			if network.AltNicks == nil {
+				network.AltNicks = altNicks
+			}
+		}
+		if network.User == "" {
+			network.User = user
+		}
+		if network.Real == "" {
+			network.Real = realName
+		}
+		if err := checkNetwork(network); err != nil {
+			return cfg, fmt.Errorf("network %q: %v", network.Name, err)
+		}
 	}
 	return
 }
 
+// checkNetwork validates the configuration of a network and fills in default
+// values.
+func checkNetwork(network *ConfigNetwork) error {
+	if network.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if network.Nick == "" {
+		return errors.New("nick is required")
+	}
+	if network.User == "" {
+		network.User = network.Nick
+	}
+	if network.Real == "" {
+		network.Real = network.Nick
+	}
+	if (network.TLSCertFile == "") != (network.TLSKeyFile == "") {
+		return errors.New("tls-certfile and tls-keyfile must be set together")
+	}
+	if network.TLSCertFile != "" && !network.TLS {
+		return errors.New("tls-certfile requires tls to be enabled")
+	}
+	if network.TLSFingerprint != "" && !network.TLS {
+		return errors.New("tls-fingerprint requires tls to be enabled")
+	}
+	for _, mech := range network.SASLMechanisms {
+		switch mech {
+		case "EXTERNAL":
+			if network.TLSCertFile == "" {
+				return errors.New("sasl-mechanism EXTERNAL requires tls-certfile")
+			}
+		case "PLAIN", "SCRAM-SHA-256":
+			if network.Password == nil {
+				return fmt.Errorf("sasl-mechanism %s requires a password", mech)
+			}
+		default:
+			return fmt.Errorf("unsupported SASL mechanism %q", mech)
+		}
+	}
+	return nil
+}
+
+// configRelPath returns p as is if it is absolute, or relative to the
+// directory of the configuration file otherwise.
+func configRelPath(filename, p string) string {
+	if p == "" || path.IsAbs(p) {
+		return p
+	}
+	return path.Join(path.Dir(filename), p)
+}
+
 func unmarshal(filename string, cfg *Config) (err error) {
 	directives, err := scfg.Load(filename)
 	if err != nil {
@@ -143,54 +299,110 @@ func unmarshal(filename string, cfg *Config) (err error) {
 
 	for _, d := range directives {
 		switch d.Name {
-		case "address":
-			if err := d.ParseParams(&cfg.Addr); err != nil {
+		case "network":
+			network := defaultNetwork()
+			if err := d.ParseParams(&network.Name); err != nil {
 				return err
 			}
-		case "nickname":
-			if err := d.ParseParams(&cfg.Nick); err != nil {
-				return err
+			if network.Name == "" || strings.ContainsAny(network.Name, " /") {
+				return fmt.Errorf("invalid network name %q", network.Name)
 			}
-		case "username":
-			if err := d.ParseParams(&cfg.User); err != nil {
-				return err
+			if strings.Trim(network.Name, "0123456789") == "" {
+				// Taken by the networks of the top-level bouncer.
+				return fmt.Errorf("invalid network name %q: it must not be a number", network.Name)
+			}
+			for _, child := range d.Children {
+				ok, err := unmarshalNetwork(filename, d.Children, child, &network)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("unknown directive %q", child.Name)
+				}
 			}
-		case "realname":
-			if err := d.ParseParams(&cfg.Real); err != nil {
+			cfg.Networks = append(cfg.Networks, network)
+		case "ignore":
+			var mask string
+			if err := d.ParseParams(&mask); err != nil {
 				return err
 			}
-		case "password":
-			// if a password-cmd is provided, don't use this value
-			if directives.Get("password-cmd") != nil {
-				continue
+			ignore := Ignore{
+				Mask:  normalizeMask(mask),
+				Kinds: IgnoreAll,
 			}
-
-			var password string
-			if err := d.ParseParams(&password); err != nil {
-				return err
+			for _, child := range d.Children {
+				switch child.Name {
+				case "network":
+					if err := child.ParseParams(&ignore.Network); err != nil {
+						return err
+					}
+				case "channel":
+					ignore.Channels = append(ignore.Channels, child.Params...)
+				case "events":
+					if ignore.Kinds, err = parseIgnoreKinds(child.Params); err != nil {
+						return err
+					}
+				default:
+					return fmt.Errorf("unknown directive %q", child.Name)
+				}
 			}
-			cfg.Password = &password
-		case "password-cmd":
-			var cmdName string
-			if err := d.ParseParams(&cmdName); err != nil {
+			cfg.Ignores = append(cfg.Ignores, ignore)
+		case "notify":
+			var name string
+			if err := d.ParseParams(&name); err != nil {
 				return err
 			}
-
-			cmd := exec.Command(cmdName, d.Params[1:]...)
-			var stdout []byte
-			if stdout, err = cmd.Output(); err != nil {
-				return fmt.Errorf("error running password command: %s", err)
+			rule := NotifyRule{
+				Buffers: d.Params[1:],
 			}
-
-			passCmdOut := strings.Split(string(stdout), "\n")
-			if len(passCmdOut) >= 1 {
-				cfg.Password = &passCmdOut[0]
+			if rule.Level, err = ParseNotifyLevel(name); err != nil {
+				return err
+			}
+			for _, child := range d.Children {
+				switch child.Name {
+				case "network":
+					if err := child.ParseParams(&rule.Network); err != nil {
+						return err
+					}
+				default:
+					return fmt.Errorf("unknown directive %q", child.Name)
+				}
 			}
-		case "channel":
-			// TODO: does this work with soju.im/bouncer-networks extension?
-			cfg.Channels = append(cfg.Channels, d.Params...)
+			cfg.Notify = append(cfg.Notify, rule)
 		case "highlight":
-			cfg.Highlights = append(cfg.Highlights, d.Params...)
+			rule := HighlightRule{
+				Words: d.Params,
+			}
+			for _, child := range d.Children {
+				switch child.Name {
+				case "regex":
+					if len(child.Params) == 0 {
+						return fmt.Errorf("directive %q requires at least one parameter", child.Name)
+					}
+					for _, expr := range child.Params {
+						// Match case-insensitively unless asked
+						// otherwise with (?-i).
+						re, err := regexp.Compile("(?i)" + expr)
+						if err != nil {
+							return fmt.Errorf("directive %q: %v", child.Name, err)
+						}
+						rule.Regexps = append(rule.Regexps, re)
+					}
+				case "substring":
+					rule.Substring = true
+				case "exclude":
+					rule.Exclude = true
+				case "network":
+					if err := child.ParseParams(&rule.Network); err != nil {
+						return err
+					}
+				case "channel":
+					rule.Channels = append(rule.Channels, child.Params...)
+				default:
+					return fmt.Errorf("unknown directive %q", child.Name)
+				}
+			}
+			cfg.Highlights = append(cfg.Highlights, rule)
 		case "on-highlight-path":
 			if err := d.ParseParams(&cfg.OnHighlightPath); err != nil {
 				return err
@@ -239,15 +451,63 @@ func unmarshal(filename string, cfg *Config) (err error) {
 					return fmt.Errorf("unknown directive %q", child.Name)
 				}
 			}
-		case "tls":
-			var tls string
-			if err := d.ParseParams(&tls); err != nil {
+		case "auto-away":
+			var duration string
+			if err := d.ParseParams(&duration); err != nil {
 				return err
 			}
-
-			if cfg.TLS, err = strconv.ParseBool(tls); err != nil {
+			if cfg.AutoAway, err = time.ParseDuration(duration); err != nil {
+				return err
+			}
+			if cfg.AutoAway < 0 {
+				return fmt.Errorf("directive %q requires a positive duration", d.Name)
+			}
+			if len(d.Params) > 1 {
+				cfg.AutoAwayMessage = strings.Join(d.Params[1:], " ")
+			}
+		case "flood-control":
+			var burst, interval string
+			if err := d.ParseParams(&burst, &interval); err != nil {
+				return err
+			}
+			if cfg.Flood.Burst, err = strconv.Atoi(burst); err != nil {
+				return err
+			}
+			if cfg.Flood.Interval, err = time.ParseDuration(interval); err != nil {
+				return err
+			}
+			if cfg.Flood.Burst < 1 || cfg.Flood.Interval < 0 {
+				return fmt.Errorf("directive %q requires a positive burst and interval", d.Name)
+			}
+		case "paste-confirm":
+			var lines string
+			if err := d.ParseParams(&lines); err != nil {
+				return err
+			}
+			if cfg.PasteLines, err = strconv.Atoi(lines); err != nil {
+				return err
+			}
+			if cfg.PasteLines < 0 {
+				return fmt.Errorf("directive %q requires a positive number of lines", d.Name)
+			}
+		case "log":
+			if err := d.ParseParams(&cfg.LogFormat); err != nil {
+				return err
+			}
+			if cfg.LogFormat != LogText && cfg.LogFormat != LogJSON {
+				return fmt.Errorf("directive %q requires %q or %q", d.Name, LogText, LogJSON)
+			}
+		case "log-keep":
+			var days string
+			if err := d.ParseParams(&days); err != nil {
+				return err
+			}
+			if cfg.LogKeep, err = strconv.Atoi(days); err != nil {
 				return err
 			}
+			if cfg.LogKeep < 0 {
+				return fmt.Errorf("directive %q requires a positive number of days", d.Name)
+			}
 		case "typings":
 			var typings string
 			if err := d.ParseParams(&typings); err != nil {
@@ -266,25 +526,69 @@ func unmarshal(filename string, cfg *Config) (err error) {
 			if cfg.Mouse, err = strconv.ParseBool(mouse); err != nil {
 				return err
 			}
+		case "theme":
+			var theme string
+			if len(d.Params) != 0 {
+				if err := d.ParseParams(&theme); err != nil {
+					return err
+				}
+			}
+			switch theme {
+			case "":
+			case "dark":
+				cfg.Theme = ui.DarkTheme()
+			case "light":
+				cfg.Theme = ui.LightTheme()
+			default:
+				block, err := scfg.Load(configRelPath(filename, theme))
+				if err != nil {
+					return fmt.Errorf("error parsing theme: %s", err)
+				}
+				cfg.Theme = ui.DarkTheme()
+				if err := unmarshalTheme(block, &cfg.Theme); err != nil {
+					return err
+				}
+			}
+			if err := unmarshalTheme(d.Children, &cfg.Theme); err != nil {
+				return err
+			}
 		case "colors":
+			if err := unmarshalTheme(d.Children, &cfg.Theme); err != nil {
+				return err
+			}
+		case "alias":
+			var name, expansion string
+			if err := d.ParseParams(&name, &expansion); err != nil {
+				return err
+			}
+			name = strings.ToUpper(strings.TrimPrefix(name, "/"))
+			if name == "" || strings.ContainsAny(name, " /") {
+				return fmt.Errorf("directive %q requires a name without spaces nor slashes", d.Name)
+			}
+			if strings.TrimSpace(expansion) == "" {
+				return fmt.Errorf("directive %q requires commands", d.Name)
+			}
+			if cfg.Aliases == nil {
+				cfg.Aliases = map[string]string{}
+			}
+			cfg.Aliases[name] = expansion
+		case "bindings":
+			if cfg.Bindings == nil {
+				cfg.Bindings = map[Key]string{}
+			}
 			for _, child := range d.Children {
-				var colorStr string
-				if err := child.ParseParams(&colorStr); err != nil {
+				var binding string
+				if err := child.ParseParams(&binding); err != nil {
 					return err
 				}
-
-				var color tcell.Color
-				if err = parseColor(colorStr, &color); err != nil {
+				k, err := ParseKey(child.Name)
+				if err != nil {
 					return err
 				}
-				switch child.Name {
-				case "prompt":
-					cfg.Colors.Prompt = color
-				case "unread":
-					cfg.Colors.Unread = color
-				default:
-					return fmt.Errorf("unknown directive %q", child.Name)
+				if err := checkBinding(binding); err != nil {
+					return err
 				}
+				cfg.Bindings[k] = binding
 			}
 		case "debug":
 			var debug string
@@ -296,9 +600,186 @@ func unmarshal(filename string, cfg *Config) (err error) {
 				return err
 			}
 		default:
-			return fmt.Errorf("unknown directive %q", d.Name)
+			ok, err := unmarshalNetwork(filename, directives, d, &cfg.ConfigNetwork)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("unknown directive %q", d.Name)
+			}
 		}
 	}
 
 	return
 }
+
+// unmarshalTheme sets the colors of theme set in block.
+func unmarshalTheme(block scfg.Block, theme *ui.Theme) error {
+	for _, d := range block {
+		switch d.Name {
+		case "nicks":
+			theme.Nicks = nil
+			for _, colorStr := range d.Params {
+				var color tcell.Color
+				if err := parseColor(colorStr, &color); err != nil {
+					return err
+				}
+				theme.Nicks = append(theme.Nicks, color)
+			}
+		case "nick":
+			var nick, colorStr string
+			if err := d.ParseParams(&nick, &colorStr); err != nil {
+				return err
+			}
+			var color tcell.Color
+			if err := parseColor(colorStr, &color); err != nil {
+				return err
+			}
+			if theme.NickTable == nil {
+				theme.NickTable = map[string]tcell.Color{}
+			}
+			theme.NickTable[strings.ToLower(nick)] = color
+		default:
+			var colorStr string
+			if err := d.ParseParams(&colorStr); err != nil {
+				return err
+			}
+			var color tcell.Color
+			if err := parseColor(colorStr, &color); err != nil {
+				return err
+			}
+			switch d.Name {
+			case "prompt":
+				theme.Prompt = color
+			case "unread":
+				theme.Unread = color
+			case "status":
+				theme.Status = color
+			case "error":
+				theme.Error = color
+			case "highlight":
+				theme.Highlight = color
+			case "join":
+				theme.Join = color
+			case "part":
+				theme.Part = color
+			case "power-level":
+				theme.PowerLevel = color
+			default:
+				return fmt.Errorf("unknown directive %q", d.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// unmarshalNetwork parses the directive d of block into network.  It returns
+// false if d is not about networks.
+func unmarshalNetwork(filename string, block scfg.Block, d *scfg.Directive, network *ConfigNetwork) (ok bool, err error) {
+	switch d.Name {
+	case "address":
+		if err := d.ParseParams(&network.Addr); err != nil {
+			return false, err
+		}
+	case "nickname":
+		if err := d.ParseParams(&network.Nick); err != nil {
+			return false, err
+		}
+	case "alt-nicknames":
+		network.AltNicks = append(network.AltNicks, d.Params...)
+	case "username":
+		if err := d.ParseParams(&network.User); err != nil {
+			return false, err
+		}
+	case "realname":
+		if err := d.ParseParams(&network.Real); err != nil {
+			return false, err
+		}
+	case "password":
+		// if a password-cmd is provided, don't use this value
+		if block.Get("password-cmd") != nil {
+			return true, nil
+		}
+
+		var password string
+		if err := d.ParseParams(&password); err != nil {
+			return false, err
+		}
+		network.Password = &password
+	case "password-cmd":
+		var cmdName string
+		if err := d.ParseParams(&cmdName); err != nil {
+			return false, err
+		}
+
+		cmd := exec.Command(cmdName, d.Params[1:]...)
+		var stdout []byte
+		if stdout, err = cmd.Output(); err != nil {
+			return false, fmt.Errorf("error running password command: %s", err)
+		}
+
+		passCmdOut := strings.Split(string(stdout), "\n")
+		if len(passCmdOut) >= 1 {
+			network.Password = &passCmdOut[0]
+		}
+	case "channel":
+		// TODO: does this work with soju.im/bouncer-networks extension?
+		network.Channels = append(network.Channels, d.Params...)
+	case "tls":
+		var tls string
+		if err := d.ParseParams(&tls); err != nil {
+			return false, err
+		}
+
+		if network.TLS, err = strconv.ParseBool(tls); err != nil {
+			return false, err
+		}
+	case "sasl-mechanism":
+		if len(d.Params) == 0 {
+			return false, fmt.Errorf("directive %q requires at least one parameter", d.Name)
+		}
+		network.SASLMechanisms = network.SASLMechanisms[:0]
+		for _, mech := range d.Params {
+			network.SASLMechanisms = append(network.SASLMechanisms, strings.ToUpper(mech))
+		}
+	case "tls-certfile":
+		var certFile string
+		if err := d.ParseParams(&certFile); err != nil {
+			return false, err
+		}
+		network.TLSCertFile = configRelPath(filename, certFile)
+	case "tls-keyfile":
+		var keyFile string
+		if err := d.ParseParams(&keyFile); err != nil {
+			return false, err
+		}
+		network.TLSKeyFile = configRelPath(filename, keyFile)
+	case "tls-fingerprint":
+		var fp string
+		if err := d.ParseParams(&fp); err != nil {
+			return false, err
+		}
+		network.TLSFingerprint = normalizeFingerprint(fp)
+		if _, err := hex.DecodeString(network.TLSFingerprint); err != nil || len(network.TLSFingerprint) != 2*sha256.Size {
+			return false, fmt.Errorf("directive %q requires a SHA-256 fingerprint in hexadecimal", d.Name)
+		}
+	case "proxy":
+		if err := d.ParseParams(&network.Proxy); err != nil {
+			return false, err
+		}
+		if err := parseProxy(network.Proxy); err != nil {
+			return false, err
+		}
+	case "tls-tofu":
+		var tofu string
+		if err := d.ParseParams(&tofu); err != nil {
+			return false, err
+		}
+		if network.TLSTOFU, err = strconv.ParseBool(tofu); err != nil {
+			return false, err
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,140 @@
+package senpai
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"git.sr.ht/~taiite/senpai/irc"
+)
+
+// HighlightRule is a rule deciding which messages highlight the user.
+type HighlightRule struct {
+	// Words are matched as whole words, unless Substring is set, with the
+	// casemapping of the server.  A rule without Words nor Regexps
+	// matches the nickname of the user.
+	Words     []string
+	Substring bool
+	Regexps   []*regexp.Regexp
+
+	// Exclude is whether messages matching the rule do not highlight the
+	// user, even when other rules match them.
+	Exclude bool
+
+	Network  string   // name of the network, empty for all networks.
+	Channels []string // empty for all channels and queries.
+}
+
+// Applies returns whether the rule applies to messages in channel (empty for
+// queries) on a network with one of the given names.
+func (r *HighlightRule) Applies(networks []string, channel string) bool {
+	if r.Network != "" {
+		found := false
+		for _, network := range networks {
+			if network == r.Network {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Channels) != 0 {
+		found := false
+		for _, c := range r.Channels {
+			if strings.EqualFold(c, channel) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Match returns the byte ranges of text matched by the rule, with casemap the
+// casemapping of the server.
+func (r *HighlightRule) Match(text string, casemap func(string) string) (spans [][2]int) {
+	textCf := casemap(text)
+	// Casemappings keep the length of valid UTF-8 text, so that ranges of
+	// textCf are ranges of text.
+	if len(textCf) == len(text) {
+		for _, word := range r.Words {
+			spans = append(spans, matchWord(textCf, casemap(word), r.Substring)...)
+		}
+	}
+	for _, re := range r.Regexps {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if loc[0] < loc[1] {
+				spans = append(spans, [2]int{loc[0], loc[1]})
+			}
+		}
+	}
+	return spans
+}
+
+// matchWord returns the byte ranges of text equal to word and, unless
+// substring is set, not surrounded by word characters.
+func matchWord(text, word string, substring bool) (spans [][2]int) {
+	if word == "" {
+		return nil
+	}
+	first, _ := utf8.DecodeRuneInString(word)
+	last, _ := utf8.DecodeLastRuneInString(word)
+	for i := 0; i < len(text); {
+		j := strings.Index(text[i:], word)
+		if j < 0 {
+			break
+		}
+		start, end := i+j, i+j+len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if substring || !(isWordRune(first) && isWordRune(before) || isWordRune(last) && isWordRune(after)) {
+			spans = append(spans, [2]int{start, end})
+			i = end
+		} else {
+			i = start + 1
+		}
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// highlightSpans returns the byte ranges of text, a message sent in channel
+// (empty for queries) on netID, that highlight the user.  It returns no ranges
+// if the message does not highlight the user.
+func (app *App) highlightSpans(netID string, s *irc.Session, channel, text string) (spans [][2]int) {
+	rules := app.cfg.Highlights
+	hasWords := false
+	for _, rule := range rules {
+		if !rule.Exclude {
+			hasWords = true
+		}
+	}
+	if !hasWords {
+		rules = append(rules[:len(rules):len(rules)], HighlightRule{})
+	}
+
+	networks := app.networkNames(netID)
+	for _, rule := range rules {
+		if !rule.Applies(networks, channel) {
+			continue
+		}
+		if len(rule.Words) == 0 && len(rule.Regexps) == 0 {
+			rule.Words = []string{s.Nick()}
+		}
+		matches := rule.Match(text, s.Casemap)
+		if rule.Exclude && len(matches) != 0 {
+			return nil
+		}
+		if !rule.Exclude {
+			spans = append(spans, matches...)
+		}
+	}
+	return spans
+}
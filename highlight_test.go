@@ -0,0 +1,54 @@
+package senpai
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"git.sr.ht/~taiite/senpai/irc"
+)
+
+func TestHighlightMatch(t *testing.T) {
+	tests := []struct {
+		rule     HighlightRule
+		text     string
+		expected [][2]int
+	}{
+		{HighlightRule{Words: []string{"al"}}, "al: hi", [][2]int{{0, 2}}},
+		{HighlightRule{Words: []string{"al"}}, "hi AL!", [][2]int{{3, 5}}},
+		{HighlightRule{Words: []string{"al"}}, "I also think so", nil},
+		{HighlightRule{Words: []string{"al"}}, "al_ and al", [][2]int{{8, 10}}},
+		{HighlightRule{Words: []string{"al"}, Substring: true}, "I also think so", [][2]int{{2, 4}}},
+		{HighlightRule{Words: []string{"al[m]"}}, "AL{M}, hi", [][2]int{{0, 5}}},
+		{HighlightRule{Words: []string{"@team"}}, "hey@team", [][2]int{{3, 8}}},
+		{HighlightRule{Words: []string{"été"}}, "un été", [][2]int{{3, 8}}},
+		{HighlightRule{Regexps: []*regexp.Regexp{regexp.MustCompile(`(?i)senpai\d+`)}}, "try Senpai2", [][2]int{{4, 11}}},
+	}
+	for _, test := range tests {
+		spans := test.rule.Match(test.text, irc.CasemapRFC1459)
+		if !reflect.DeepEqual(spans, test.expected) {
+			t.Errorf("%+v in %q: expected %v, got %v", test.rule, test.text, test.expected, spans)
+		}
+	}
+}
+
+func TestHighlightApplies(t *testing.T) {
+	rule := HighlightRule{
+		Network:  "libera",
+		Channels: []string{"#Senpai"},
+	}
+	networks := []string{"libera"}
+
+	if !rule.Applies(networks, "#senpai") {
+		t.Errorf("expected the rule to apply to #senpai")
+	}
+	if rule.Applies(networks, "#other") {
+		t.Errorf("expected the rule not to apply to #other")
+	}
+	if rule.Applies(networks, "") {
+		t.Errorf("expected the rule not to apply to queries")
+	}
+	if rule.Applies([]string{"oftc"}, "#senpai") {
+		t.Errorf("expected the rule not to apply to another network")
+	}
+}
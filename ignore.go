@@ -0,0 +1,144 @@
+package senpai
+
+import (
+	"fmt"
+	"strings"
+
+	"git.sr.ht/~taiite/senpai/irc"
+)
+
+// IgnoreKind is a set of kinds of events hidden by an ignore rule.
+type IgnoreKind int
+
+const (
+	IgnoreMessages IgnoreKind = 1 << iota // messages, notices and CTCP.
+	IgnoreJoins                           // joins, parts and quits.
+	IgnoreTyping                          // typing notifications.
+
+	IgnoreAll = IgnoreMessages | IgnoreJoins | IgnoreTyping
+)
+
+var ignoreKindNames = []struct {
+	kind IgnoreKind
+	name string
+}{
+	{IgnoreMessages, "messages"},
+	{IgnoreJoins, "joins"},
+	{IgnoreTyping, "typing"},
+}
+
+// parseIgnoreKinds returns the set of the given kind names, or IgnoreAll if
+// names is empty.
+func parseIgnoreKinds(names []string) (IgnoreKind, error) {
+	if len(names) == 0 {
+		return IgnoreAll, nil
+	}
+	var kinds IgnoreKind
+	for _, name := range names {
+		found := false
+		for _, k := range ignoreKindNames {
+			if strings.EqualFold(name, k.name) {
+				kinds |= k.kind
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown event kind %q, expected messages, joins or typing", name)
+		}
+	}
+	return kinds, nil
+}
+
+func (k IgnoreKind) String() string {
+	var names []string
+	for _, kn := range ignoreKindNames {
+		if k&kn.kind != 0 {
+			names = append(names, kn.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// Ignore is a rule that hides the events of the users matching Mask.
+type Ignore struct {
+	Mask     string     // nick!user@host glob pattern, see normalizeMask.
+	Network  string     // name of the network, empty for all networks.
+	Channels []string   // empty for all channels and queries.
+	Kinds    IgnoreKind // kinds of events hidden.
+}
+
+// Match returns whether the rule hides events of the given kind, sent by user
+// in channel (empty for queries) on a network with one of the given names.
+func (ig *Ignore) Match(networks []string, channel string, user *irc.Prefix, kind IgnoreKind) bool {
+	if ig.Kinds&kind == 0 {
+		return false
+	}
+	if ig.Network != "" {
+		found := false
+		for _, network := range networks {
+			if network == ig.Network {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(ig.Channels) != 0 {
+		found := false
+		for _, c := range ig.Channels {
+			if irc.CasemapRFC1459(c) == irc.CasemapRFC1459(channel) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	source := user.Name + "!" + user.User + "@" + user.Host
+	return matchMask(irc.CasemapRFC1459(ig.Mask), irc.CasemapRFC1459(source))
+}
+
+// normalizeMask completes the missing parts of mask, so that "nick" becomes
+// "nick!*@*" and "user@host" becomes "*!user@host".
+func normalizeMask(mask string) string {
+	if !strings.Contains(mask, "!") {
+		if strings.Contains(mask, "@") {
+			mask = "*!" + mask
+		} else {
+			mask += "!*"
+		}
+	}
+	if !strings.Contains(mask, "@") {
+		mask += "@*"
+	}
+	return mask
+}
+
+// matchMask returns whether s matches the glob pattern mask, in which "*"
+// matches any string and "?" any character.
+func matchMask(mask, s string) bool {
+	m, n := []rune(mask), []rune(s)
+	mi, ni := 0, 0
+	star, starN := -1, 0
+	for ni < len(n) {
+		switch {
+		case mi < len(m) && (m[mi] == '?' || m[mi] == n[ni]):
+			mi++
+			ni++
+		case mi < len(m) && m[mi] == '*':
+			star, starN = mi, ni
+			mi++
+		case star >= 0:
+			// Let the last star match one more character.
+			starN++
+			mi, ni = star+1, starN
+		default:
+			return false
+		}
+	}
+	for mi < len(m) && m[mi] == '*' {
+		mi++
+	}
+	return mi == len(m)
+}
@@ -0,0 +1,90 @@
+package senpai
+
+import (
+	"testing"
+
+	"git.sr.ht/~taiite/senpai/irc"
+)
+
+func TestNormalizeMask(t *testing.T) {
+	for mask, expected := range map[string]string{
+		"dan":             "dan!*@*",
+		"dan!d":           "dan!d@*",
+		"d@example.org":   "*!d@example.org",
+		"dan!d@localhost": "dan!d@localhost",
+	} {
+		if actual := normalizeMask(mask); actual != expected {
+			t.Errorf("%q: expected %q, got %q", mask, expected, actual)
+		}
+	}
+}
+
+func TestMatchMask(t *testing.T) {
+	for _, tc := range []struct {
+		mask     string
+		s        string
+		expected bool
+	}{
+		{"dan!*@*", "dan!d@example.org", true},
+		{"dan!*@*", "dan_!d@example.org", false},
+		{"*!*@example.org", "dan!d@example.org", true},
+		{"*!*@*.example.org", "dan!d@example.org", false},
+		{"d?n!*@*", "don!d@", true},
+		{"*a*a*!*@*", "banana!b@host", true},
+		{"*a*a*!*@*", "bon!b@host", false},
+	} {
+		if actual := matchMask(tc.mask, tc.s); actual != tc.expected {
+			t.Errorf("%q with %q: expected %v, got %v", tc.mask, tc.s, tc.expected, actual)
+		}
+	}
+}
+
+func TestIgnoreMatch(t *testing.T) {
+	ignore := Ignore{
+		Mask:     "*!*@example.org",
+		Network:  "libera",
+		Channels: []string{"#Senpai"},
+		Kinds:    IgnoreMessages | IgnoreTyping,
+	}
+	user := &irc.Prefix{Name: "dan", User: "d", Host: "EXAMPLE.org"}
+	networks := []string{"libera"}
+
+	if !ignore.Match(networks, "#senpai", user, IgnoreMessages) {
+		t.Errorf("expected messages in #senpai to be ignored")
+	}
+	if ignore.Match(networks, "#senpai", user, IgnoreJoins) {
+		t.Errorf("expected joins to be shown")
+	}
+	if ignore.Match(networks, "#other", user, IgnoreMessages) {
+		t.Errorf("expected messages in #other to be shown")
+	}
+	if ignore.Match([]string{"oftc"}, "#senpai", user, IgnoreMessages) {
+		t.Errorf("expected messages on another network to be shown")
+	}
+	if ignore.Match(networks, "#senpai", &irc.Prefix{Name: "dan"}, IgnoreMessages) {
+		t.Errorf("expected messages from an unknown host to be shown")
+	}
+}
+
+func TestFilterIgnoredLabeled(t *testing.T) {
+	s := irc.NewSession(make(chan irc.Message, 64), irc.SessionParams{
+		Nickname: "nick",
+		Username: "user",
+		RealName: "real",
+	})
+	app := &App{
+		networks: map[string]ConfigNetwork{"": {}},
+		ignores:  []Ignore{{Mask: "dan!*@*", Kinds: IgnoreAll}},
+	}
+	ev := app.filterIgnored("", s, "", irc.LabeledEvent{
+		Origin: "#senpai",
+		Events: []irc.Event{
+			irc.UserJoinEvent{User: "dan", Prefix: &irc.Prefix{Name: "dan"}, Channel: "#senpai"},
+			irc.UserJoinEvent{User: "nick", Prefix: &irc.Prefix{Name: "nick"}, Channel: "#senpai"},
+		},
+	})
+	labeled, ok := ev.(irc.LabeledEvent)
+	if !ok || len(labeled.Events) != 1 || labeled.Events[0].(irc.UserJoinEvent).User != "nick" {
+		t.Errorf("expected the replies from dan to be hidden, got %#v", ev)
+	}
+}
@@ -2,15 +2,192 @@ package irc
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
 const chanCapacity = 64
 
-func ChanInOut(conn net.Conn) (in <-chan Message, out chan<- Message) {
+// FloodControl limits the rate at which messages are written to the server, so
+// that it does not disconnect us for flooding.
+type FloodControl struct {
+	Burst    int           // number of messages written at once.
+	Interval time.Duration // time between messages once the burst is spent, 0 for no limit.
+}
+
+// Priorities of outgoing messages, from the most urgent.
+const (
+	priorityHigh = iota
+	priorityLow
+	priorityCount
+)
+
+// messagePriority returns the priority of msg in the send queue.  Messages of
+// the same priority are written in order.
+func messagePriority(msg Message) int {
+	switch msg.Command {
+	case "PING", "PONG", "QUIT":
+		return priorityHigh
+	default:
+		return priorityLow
+	}
+}
+
+// Conn is the state of a connection handled by ChanInOut: the outgoing
+// messages waiting to be written, and the lag measured with pings.
+type Conn struct {
+	out  chan Message
+	wake chan struct{}
+
+	mu         sync.Mutex
+	pending    [priorityCount][]Message
+	closed     bool
+	registered bool      // whether RPL_WELCOME has been read.
+	lastPing   time.Time // time of the last PING sent.
+	pingSent   time.Time // time of the PING waiting for a PONG, if any.
+	lag        time.Duration
+}
+
+// Pending returns the number of messages waiting to be written.
+func (c *Conn) Pending() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.out)
+	for _, msgs := range c.pending {
+		n += len(msgs)
+	}
+	return n
+}
+
+func (c *Conn) push(msg Message) {
+	p := messagePriority(msg)
+	c.mu.Lock()
+	c.pending[p] = append(c.pending[p], msg)
+	c.mu.Unlock()
+	c.notify()
+}
+
+func (c *Conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.notify()
+}
+
+func (c *Conn) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next blocks until a message is to be written, and returns false once the
+// queue is closed.
+func (c *Conn) next() (Message, bool) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			// Only urgent messages, such as QUIT, are still worth
+			// writing.
+			c.pending[priorityLow] = nil
+		}
+		for p, msgs := range c.pending {
+			if len(msgs) != 0 {
+				c.pending[p] = msgs[1:]
+				c.mu.Unlock()
+				return msgs[0], true
+			}
+		}
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return Message{}, false
+		}
+		<-c.wake
+	}
+}
+
+// Lag returns the time the server took to reply to the last PING, or the time
+// since the PING waiting for a reply, if longer.  It is 0 until a PING is
+// sent.
+func (c *Conn) Lag() time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.pingSent.IsZero() {
+		if waiting := time.Since(c.pingSent); c.lag < waiting {
+			return waiting
+		}
+	}
+	return c.lag
+}
+
+// read records the arrival of msg at the given time.
+func (c *Conn) read(msg Message, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if msg.Command == rplWelcome {
+		c.registered = true
+	}
+	if msg.Command == "PONG" && !c.pingSent.IsZero() && len(msg.Params) != 0 && msg.Params[len(msg.Params)-1] == pingToken {
+		c.lag = now.Sub(c.pingSent)
+		c.pingSent = time.Time{}
+	}
+}
+
+// isRegistered returns whether the server has accepted the registration.
+// Messages are written without flood control until then, since registration
+// takes more messages than the usual burst.
+func (c *Conn) isRegistered() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.registered
+}
+
+// checkPing returns whether a PING must be sent to measure the lag, every
+// pingInterval whatever the traffic, or whether the connection must be closed,
+// because the last PING has not been replied to for pingTimeout.
+func (c *Conn) checkPing(now time.Time) (ping, timeout bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.pingSent.IsZero() {
+		return false, pingTimeout <= now.Sub(c.pingSent)
+	}
+	if pingInterval <= now.Sub(c.lastPing) {
+		c.pingSent = now
+		c.lastPing = now
+		return true, false
+	}
+	return false, false
+}
+
+const (
+	pingToken    = "senpai"
+	pingInterval = time.Minute
+	pingTimeout  = 30 * time.Second
+)
+
+// ChanInOut reads messages from conn into in, and writes messages from out to
+// conn, at the rate allowed by flood.  Closing out closes conn.  conn is also
+// closed when it stays silent, despite a PING.
+func ChanInOut(conn net.Conn, flood FloodControl) (in <-chan Message, out chan<- Message, c *Conn) {
 	in_ := make(chan Message, chanCapacity)
-	out_ := make(chan Message, chanCapacity)
+	done := make(chan struct{})
+	c = &Conn{
+		out:      make(chan Message, chanCapacity),
+		wake:     make(chan struct{}, 1),
+		lastPing: time.Now(),
+	}
 
 	go func() {
 		r := bufio.NewScanner(conn)
@@ -20,14 +197,60 @@ func ChanInOut(conn net.Conn) (in <-chan Message, out chan<- Message) {
 			if err != nil {
 				continue
 			}
+			c.read(msg, time.Now())
 			in_ <- msg
 		}
+		close(done)
 		close(in_)
 	}()
 
 	go func() {
-		for msg := range out_ {
-			// TODO send messages by batches
+		t := time.NewTicker(pingTimeout / 6)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				ping, timeout := c.checkPing(now)
+				if ping {
+					c.push(NewMessage("PING", pingToken))
+				}
+				if timeout {
+					// Unblock the reading goroutine, and writes.
+					_ = conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		// Never block senders, whatever the state of the connection.
+		for msg := range c.out {
+			c.push(msg)
+		}
+		c.close()
+	}()
+
+	go func() {
+		limit := rate.Inf
+		if flood.Interval != 0 {
+			limit = rate.Every(flood.Interval)
+		}
+		burst := flood.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		limiter := rate.NewLimiter(limit, burst)
+		for {
+			msg, ok := c.next()
+			if !ok {
+				break
+			}
+			if c.isRegistered() {
+				_ = limiter.Wait(context.Background())
+			}
 			_, err := fmt.Fprintf(conn, "%s\r\n", msg.String())
 			if err != nil {
 				break
@@ -36,5 +259,5 @@ func ChanInOut(conn net.Conn) (in <-chan Message, out chan<- Message) {
 		_ = conn.Close()
 	}()
 
-	return in_, out_
+	return in_, c.out, c
 }
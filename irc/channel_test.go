@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	q := &Conn{wake: make(chan struct{}, 1)}
+	q.push(NewMessage("PRIVMSG", "#senpai", "a"))
+	q.push(NewMessage("PRIVMSG", "#senpai", "b"))
+	q.push(NewMessage("PONG", "server"))
+	if n := q.Pending(); n != 3 {
+		t.Fatalf("expected 3 pending messages, got %d", n)
+	}
+	for _, expected := range []string{"PONG", "PRIVMSG a", "PRIVMSG b"} {
+		msg, ok := q.next()
+		got := msg.Command
+		if msg.Command == "PRIVMSG" {
+			got += " " + msg.Params[1]
+		}
+		if !ok || got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	}
+
+	q.push(NewMessage("PRIVMSG", "#senpai", "c"))
+	q.push(NewMessage("QUIT"))
+	q.close()
+	if msg, ok := q.next(); !ok || msg.Command != "QUIT" {
+		t.Errorf("expected QUIT, got %q", msg.Command)
+	}
+	if msg, ok := q.next(); ok {
+		t.Errorf("expected the queue to be closed, got %q", msg.Command)
+	}
+}
+
+func TestFloodControlRegistration(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	_, out, c := ChanInOut(client, FloodControl{Burst: 1, Interval: time.Hour})
+	defer close(out)
+	lines := make(chan string, 16)
+	go func() {
+		r := bufio.NewScanner(server)
+		for r.Scan() {
+			lines <- r.Text()
+		}
+		close(lines)
+	}()
+	receive := func() (string, bool) {
+		select {
+		case line := <-lines:
+			return line, true
+		case <-time.After(time.Second):
+			return "", false
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		out <- NewMessage("CAP", "REQ", "batch")
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := receive(); !ok {
+			t.Fatalf("registration message %d delayed by flood control", i)
+		}
+	}
+
+	if _, err := server.Write([]byte(":server 001 nick :Welcome\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for !c.isRegistered() {
+		time.Sleep(time.Millisecond)
+	}
+	out <- NewMessage("PING", "a")
+	if _, ok := receive(); !ok {
+		t.Fatalf("expected the burst to allow a message after registration")
+	}
+	out <- NewMessage("PRIVMSG", "#senpai", "b")
+	select {
+	case line := <-lines:
+		t.Errorf("expected flood control after registration, got %q", line)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPing(t *testing.T) {
+	start := time.Now()
+	c := &Conn{lastPing: start}
+	if ping, timeout := c.checkPing(start.Add(pingInterval / 2)); ping || timeout {
+		t.Errorf("unexpected ping or timeout before the interval")
+	}
+	// Traffic does not delay pings, so that the lag is measured on busy
+	// connections too.
+	c.read(NewMessage("PRIVMSG", "#senpai", "hello"), start.Add(pingInterval-time.Second))
+	if ping, _ := c.checkPing(start.Add(pingInterval)); !ping {
+		t.Fatalf("expected a ping after the interval")
+	}
+	if ping, _ := c.checkPing(start.Add(pingInterval + time.Second)); ping {
+		t.Errorf("expected a single ping")
+	}
+
+	c.read(NewMessage("PONG", "server", pingToken), start.Add(pingInterval+2*time.Second))
+	if lag := c.Lag(); lag != 2*time.Second {
+		t.Errorf("expected a lag of 2s, got %v", lag)
+	}
+
+	if ping, _ := c.checkPing(start.Add(2*pingInterval - time.Second)); ping {
+		t.Errorf("unexpected ping before the next interval")
+	}
+	if ping, _ := c.checkPing(start.Add(2 * pingInterval)); !ping {
+		t.Fatalf("expected a second ping")
+	}
+	if _, timeout := c.checkPing(start.Add(2*pingInterval + pingTimeout)); !timeout {
+		t.Errorf("expected a timeout without pong")
+	}
+}
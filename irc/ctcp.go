@@ -0,0 +1,69 @@
+package irc
+
+import (
+	"strings"
+	"time"
+)
+
+// ctcpCommands are the CTCP requests senpai understands, as listed in replies
+// to CLIENTINFO.
+var ctcpCommands = []string{"ACTION", "CLIENTINFO", "PING", "TIME", "VERSION"}
+
+// ParseCTCP returns the command and parameters of the CTCP message held by the
+// content of a PRIVMSG or NOTICE.  ok is false if content is not a CTCP
+// message.
+func ParseCTCP(content string) (command, params string, ok bool) {
+	if !strings.HasPrefix(content, "\x01") {
+		return "", "", false
+	}
+	content = strings.TrimPrefix(content, "\x01")
+	content = strings.TrimSuffix(content, "\x01")
+
+	command = content
+	if i := strings.IndexByte(content, ' '); i >= 0 {
+		command, params = content[:i], content[i+1:]
+	}
+	if command == "" {
+		return "", "", false
+	}
+	return strings.ToUpper(command), params, true
+}
+
+// FormatCTCP returns the content of a PRIVMSG or NOTICE holding the given
+// CTCP message.
+func FormatCTCP(command, params string) string {
+	if params == "" {
+		return "\x01" + command + "\x01"
+	}
+	return "\x01" + command + " " + params + "\x01"
+}
+
+// isCTCPIgnored reports whether the CTCP requests of user sent to target must
+// not be replied to.
+func (s *Session) isCTCPIgnored(user *Prefix, target string) bool {
+	if s.ignoreCTCP == nil {
+		return false
+	}
+	channel := ""
+	if s.IsChannel(target) {
+		channel = target
+	}
+	return s.ignoreCTCP(user, channel)
+}
+
+// ctcpReply returns the parameters of the reply to a CTCP request, and false if
+// the request must not be replied to.
+func (s *Session) ctcpReply(command, params string) (string, bool) {
+	switch command {
+	case "CLIENTINFO":
+		return strings.Join(ctcpCommands, " "), true
+	case "PING":
+		return params, true
+	case "TIME":
+		return time.Now().Format(time.RFC1123Z), true
+	case "VERSION":
+		return s.ctcpVersion, s.ctcpVersion != ""
+	default:
+		return "", false
+	}
+}
@@ -12,10 +12,30 @@ type ErrorEvent struct {
 
 type RegisteredEvent struct{}
 
+// NickInUseEvent is sent during registration when a nickname is already in
+// use and another one is tried instead.
+type NickInUseEvent struct {
+	Nick string
+	Next string
+}
+
+// NickRegainEvent reports the progress of getting back the nickname given in
+// SessionParams, after registration completed with another one.
+type NickRegainEvent struct {
+	Nick      string
+	Available bool // whether the nickname is free and has been requested.
+}
+
 type SelfNickEvent struct {
 	FormerNick string
 }
 
+// SelfAwayEvent is sent when the server confirms that our away status has
+// changed.
+type SelfAwayEvent struct {
+	Away bool
+}
+
 type UserNickEvent struct {
 	User       string
 	FormerNick string
@@ -30,6 +50,7 @@ type SelfJoinEvent struct {
 
 type UserJoinEvent struct {
 	User    string
+	Prefix  *Prefix // nick, user and hostname of User, if known.
 	Channel string
 	Time    time.Time
 }
@@ -40,12 +61,14 @@ type SelfPartEvent struct {
 
 type UserPartEvent struct {
 	User    string
+	Prefix  *Prefix // nick, user and hostname of User, if known.
 	Channel string
 	Time    time.Time
 }
 
 type UserQuitEvent struct {
 	User     string
+	Prefix   *Prefix // nick, user and hostname of User, if known.
 	Channels []string
 	Time     time.Time
 }
@@ -78,11 +101,45 @@ type InviteEvent struct {
 
 type MessageEvent struct {
 	User            string
+	Prefix          *Prefix // nick, user and hostname of User, if known.
 	Target          string
 	TargetIsChannel bool
 	Command         string
 	Content         string
 	Time            time.Time
+	ID              string // msgid of the message, empty if unknown.
+	ReplyTo         string // msgid of the message this one replies to, if any.
+}
+
+// ReactionEvent is a reaction of User to the message of ID ReplyTo, sent with
+// the +draft/react client tag.
+type ReactionEvent struct {
+	User            string
+	Prefix          *Prefix // nick, user and hostname of User, if known.
+	Target          string
+	TargetIsChannel bool
+	ReplyTo         string
+	Reaction        string
+	Time            time.Time
+}
+
+// CTCPEvent is a CTCP request (sent with PRIVMSG) or reply (sent with NOTICE)
+// other than ACTION, which is a MessageEvent.
+type CTCPEvent struct {
+	User    string
+	Prefix  *Prefix // nick, user and hostname of User, if known.
+	Target  string
+	Command string // uppercased, such as "VERSION".
+	Params  string
+	Reply   bool
+	Time    time.Time
+}
+
+// STSEvent is a Strict Transport Security policy advertised by the server with
+// the sts capability.
+type STSEvent struct {
+	Port     string        // port to use TLS on, for insecure connections.
+	Duration time.Duration // how long to use TLS for, negative if not given.
 }
 
 type HistoryEvent struct {
@@ -104,6 +161,38 @@ type SearchEvent struct {
 }
 
 type BouncerNetworkEvent struct {
-	ID   string
-	Name string
+	ID      string
+	Name    string
+	State   string // "connected", "connecting", "disconnected" or "" if unknown.
+	Deleted bool
+}
+
+// LabeledEvent holds the replies of the server to a message sent from
+// Session.Labeled.
+type LabeledEvent struct {
+	Label  string
+	Origin string  // the origin given to Session.Labeled.
+	Events []Event // the replies, empty if the server only acknowledged.
+}
+
+// WhoisEvent holds the replies to a WHOIS request.
+type WhoisEvent struct {
+	Nick       string
+	User       string
+	Host       string
+	RealName   string
+	Server     string
+	ServerInfo string
+	Account    string // empty if not logged in.
+	Operator   bool
+	Away       string // the away message, empty if not away.
+	Channels   []WhoisChannel
+	Idle       time.Duration
+	Signon     time.Time // zero if unknown.
+}
+
+// WhoisChannel is a channel listed in WHOIS replies.
+type WhoisChannel struct {
+	Name       string
+	PowerLevel string // the membership prefixes of the user, such as "@".
 }
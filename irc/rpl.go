@@ -32,6 +32,7 @@ const (
 	rplList            = "322" // <channel> <# of visible members> <topic>
 	rplListend         = "323" // :End of list
 	rplChannelmodeis   = "324" // <channel> <modes> <mode params>
+	rplWhoisaccount    = "330" // <nick> <account> :is logged in as
 	rplNotopic         = "331" // <channel> :No topic set
 	rplTopic           = "332" // <channel> <topic>
 	rplTopicwhotime    = "333" // <channel> <nick> <setat>
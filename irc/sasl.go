@@ -0,0 +1,264 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type SASLClient interface {
+	Early() bool
+	Handshake() (mech string)
+	Respond(challenge string) (res string, err error)
+}
+
+type SASLPlain struct {
+	Username string
+	Password string
+}
+
+func (auth *SASLPlain) Early() bool {
+	return true
+}
+
+func (auth *SASLPlain) Handshake() (mech string) {
+	mech = "PLAIN"
+	return
+}
+
+func (auth *SASLPlain) Respond(challenge string) (res string, err error) {
+	if challenge != "+" {
+		err = errors.New("unexpected challenge")
+		return
+	}
+
+	user := []byte(auth.Username)
+	pass := []byte(auth.Password)
+	payload := bytes.Join([][]byte{user, user, pass}, []byte{0})
+	res = base64.StdEncoding.EncodeToString(payload)
+
+	return
+}
+
+// SASLExternal authenticates with credentials established outside of the IRC
+// connection, usually a TLS client certificate.
+type SASLExternal struct{}
+
+func (auth *SASLExternal) Early() bool {
+	return true
+}
+
+func (auth *SASLExternal) Handshake() (mech string) {
+	mech = "EXTERNAL"
+	return
+}
+
+func (auth *SASLExternal) Respond(challenge string) (res string, err error) {
+	if challenge != "+" {
+		err = errors.New("unexpected challenge")
+		return
+	}
+
+	// empty authorization identity: the server derives it from the
+	// certificate.
+	res = "+"
+
+	return
+}
+
+// scramNonce returns a random client nonce.  It is a variable so that tests
+// can use the nonces of the RFC examples.
+var scramNonce = func() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(buf), nil
+}
+
+// SASLScramSHA256 implements the SCRAM-SHA-256 mechanism, as defined by RFC
+// 7677.  The password is never sent to the server, and the server has to prove
+// it knows it too.
+//
+// The password is used as is, without SASLprep normalization.
+type SASLScramSHA256 struct {
+	Username string
+	Password string
+
+	step            int
+	clientFirstBare string
+	clientNonce     string
+	serverSignature []byte
+}
+
+func (auth *SASLScramSHA256) Early() bool {
+	return false
+}
+
+func (auth *SASLScramSHA256) Handshake() (mech string) {
+	mech = "SCRAM-SHA-256"
+	return
+}
+
+func (auth *SASLScramSHA256) Respond(challenge string) (res string, err error) {
+	if challenge == "+" {
+		// The server only sends an empty challenge to start the
+		// exchange, which might be a new attempt.
+		auth.step = 0
+	}
+
+	var payload string
+	switch auth.step {
+	case 0:
+		if challenge != "+" {
+			err = errors.New("unexpected challenge")
+			return
+		}
+		payload, err = auth.clientFirst()
+	case 1:
+		payload, err = auth.clientFinal(challenge)
+	case 2:
+		err = auth.verifyServerFinal(challenge)
+		payload = ""
+	default:
+		err = errors.New("unexpected challenge")
+	}
+	if err != nil {
+		return
+	}
+	auth.step++
+
+	if payload == "" {
+		res = "+"
+	} else {
+		res = base64.StdEncoding.EncodeToString([]byte(payload))
+	}
+	return
+}
+
+func (auth *SASLScramSHA256) clientFirst() (string, error) {
+	nonce, err := scramNonce()
+	if err != nil {
+		return "", err
+	}
+	auth.clientNonce = nonce
+	auth.clientFirstBare = "n=" + scramEscape(auth.Username) + ",r=" + nonce
+	return "n,," + auth.clientFirstBare, nil
+}
+
+func (auth *SASLScramSHA256) clientFinal(challenge string) (string, error) {
+	serverFirst, err := base64.StdEncoding.DecodeString(challenge)
+	if err != nil {
+		return "", fmt.Errorf("malformed server-first-message: %v", err)
+	}
+	attrs := scramAttributes(string(serverFirst))
+	if e, ok := attrs["e"]; ok {
+		return "", fmt.Errorf("server error: %s", e)
+	}
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, auth.clientNonce) || len(nonce) == len(auth.clientNonce) {
+		return "", errors.New("invalid server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return "", fmt.Errorf("invalid salt: %v", err)
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations <= 0 {
+		return "", errors.New("invalid iteration count")
+	}
+
+	saltedPassword := scramHi([]byte(auth.Password), salt, iterations)
+	clientKey := scramHMAC(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := scramHMAC(saltedPassword, []byte("Server Key"))
+
+	// "biws" is the base64 encoding of the GS2 header "n,,".
+	clientFinalBare := "c=biws,r=" + nonce
+	authMessage := []byte(auth.clientFirstBare + "," + string(serverFirst) + "," + clientFinalBare)
+
+	clientSignature := scramHMAC(storedKey[:], authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	auth.serverSignature = scramHMAC(serverKey, authMessage)
+
+	return clientFinalBare + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (auth *SASLScramSHA256) verifyServerFinal(challenge string) error {
+	serverFinal, err := base64.StdEncoding.DecodeString(challenge)
+	if err != nil {
+		return fmt.Errorf("malformed server-final-message: %v", err)
+	}
+	attrs := scramAttributes(string(serverFinal))
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("server error: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(signature, auth.serverSignature) {
+		return errors.New("invalid server signature")
+	}
+	return nil
+}
+
+// scramEscape escapes a SCRAM saslname.
+func scramEscape(name string) string {
+	name = strings.ReplaceAll(name, "=", "=3D")
+	name = strings.ReplaceAll(name, ",", "=2C")
+	return name
+}
+
+// scramAttributes parses the "a=value,b=value" attribute list of SCRAM
+// server messages.
+func scramAttributes(msg string) map[string]string {
+	attrs := map[string]string{}
+	for _, attr := range strings.Split(msg, ",") {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 || len(kv[0]) != 1 {
+			continue
+		}
+		attrs[kv[0]] = kv[1]
+	}
+	return attrs
+}
+
+func scramHMAC(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// scramHi is the Hi() function of RFC 5802, that is PBKDF2 with HMAC-SHA-256
+// and an output length equal to the hash length.
+func scramHi(password, salt []byte, iterations int) []byte {
+	u := scramHMAC(password, append(append([]byte{}, salt...), 0, 0, 0, 1))
+	res := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = scramHMAC(password, u)
+		for j := range res {
+			res[j] ^= u[j]
+		}
+	}
+	return res
+}
+
+// saslMechanisms parses the comma-separated list of mechanisms found in the
+// "sasl" capability value and in RPL_SASLMECHS.
+func saslMechanisms(s string) []string {
+	if s == "" {
+		return nil
+	}
+	mechs := strings.Split(s, ",")
+	for i := range mechs {
+		mechs[i] = strings.ToUpper(mechs[i])
+	}
+	return mechs
+}
@@ -0,0 +1,78 @@
+package irc
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// setScramNonce makes SCRAM clients use nonce for the duration of the test.
+func setScramNonce(t *testing.T, nonce string) {
+	old := scramNonce
+	scramNonce = func() (string, error) {
+		return nonce, nil
+	}
+	t.Cleanup(func() {
+		scramNonce = old
+	})
+}
+
+// Example exchange from RFC 7677, section 3.
+func TestSASLScramSHA256(t *testing.T) {
+	setScramNonce(t, "rOprNGfwEbeRWgbNEkqO")
+
+	auth := &SASLScramSHA256{Username: "user", Password: "pencil"}
+	if mech := auth.Handshake(); mech != "SCRAM-SHA-256" {
+		t.Fatalf("expected mechanism SCRAM-SHA-256, got %q", mech)
+	}
+
+	steps := []struct {
+		challenge string
+		response  string
+	}{
+		{
+			challenge: "+",
+			response:  b64("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"),
+		},
+		{
+			challenge: b64("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"),
+			response:  b64("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="),
+		},
+		{
+			challenge: b64("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="),
+			response:  "+",
+		},
+	}
+	for i, step := range steps {
+		res, err := auth.Respond(step.challenge)
+		if err != nil {
+			t.Fatalf("step #%d: unexpected error: %v", i, err)
+		}
+		if res != step.response {
+			t.Errorf("step #%d: expected %q, got %q", i, step.response, res)
+		}
+	}
+
+	// A new attempt starts from scratch.
+	if res, err := auth.Respond("+"); err != nil || res != steps[0].response {
+		t.Errorf("restart: expected %q, got %q (%v)", steps[0].response, res, err)
+	}
+}
+
+func TestSASLScramSHA256BadServerSignature(t *testing.T) {
+	setScramNonce(t, "rOprNGfwEbeRWgbNEkqO")
+
+	auth := &SASLScramSHA256{Username: "user", Password: "not pencil"}
+	if _, err := auth.Respond("+"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := auth.Respond(b64("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := auth.Respond(b64("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")); err == nil {
+		t.Errorf("expected the server signature to be rejected")
+	}
+}
@@ -1,9 +1,6 @@
 package irc
 
 import (
-	"bytes"
-	"encoding/base64"
-	"errors"
 	"fmt"
 	"sort"
 	"strconv"
@@ -15,58 +12,27 @@ import (
 	"golang.org/x/time/rate"
 )
 
-type SASLClient interface {
-	Early() bool
-	Handshake() (mech string)
-	Respond(challenge string) (res string, err error)
-}
-
-type SASLPlain struct {
-	Username string
-	Password string
-}
-
-func (auth *SASLPlain) Early() bool {
-	return true
-}
-
-func (auth *SASLPlain) Handshake() (mech string) {
-	mech = "PLAIN"
-	return
-}
-
-func (auth *SASLPlain) Respond(challenge string) (res string, err error) {
-	if challenge != "+" {
-		err = errors.New("unexpected challenge")
-		return
-	}
-
-	user := []byte(auth.Username)
-	pass := []byte(auth.Password)
-	payload := bytes.Join([][]byte{user, user, pass}, []byte{0})
-	res = base64.StdEncoding.EncodeToString(payload)
-
-	return
-}
-
 // SupportedCapabilities is the set of capabilities supported by this library.
 var SupportedCapabilities = map[string]struct{}{
-	"away-notify":   {},
-	"batch":         {},
-	"cap-notify":    {},
-	"echo-message":  {},
-	"invite-notify": {},
-	"message-tags":  {},
-	"multi-prefix":  {},
-	"server-time":   {},
-	"sasl":          {},
-	"setname":       {},
-
-	"draft/chathistory":        {},
-	"draft/event-playback":     {},
-	"soju.im/bouncer-networks": {},
-	"soju.im/read":             {},
-	"soju.im/search":           {},
+	"away-notify":      {},
+	"batch":            {},
+	"cap-notify":       {},
+	"echo-message":     {},
+	"invite-notify":    {},
+	"labeled-response": {},
+	"message-tags":     {},
+	"multi-prefix":     {},
+	"server-time":      {},
+	"sasl":             {},
+	"setname":          {},
+
+	"draft/chathistory":               {},
+	"draft/event-playback":            {},
+	"draft/multiline":                 {},
+	"soju.im/bouncer-networks":        {},
+	"soju.im/bouncer-networks-notify": {},
+	"soju.im/read":                    {},
+	"soju.im/search":                  {},
 }
 
 // Values taken by the "@+typing=" client tag.  TypingUnspec means the value or
@@ -96,6 +62,13 @@ type Channel struct {
 	complete bool // whether this structure is fully initialized.
 }
 
+// BouncerNetwork is a network of a bouncer, as defined by the
+// soju.im/bouncer-networks extension.
+type BouncerNetwork struct {
+	ID    string
+	Attrs map[string]string // attributes such as "name", "host" or "state".
+}
+
 // SessionParams defines how to connect to an IRC server.
 type SessionParams struct {
 	Nickname string
@@ -103,10 +76,31 @@ type SessionParams struct {
 	RealName string
 	NetID    string
 	Auth     SASLClient
+
+	// AuthFallbacks are the SASL mechanisms to try, in order, when the
+	// server does not support the mechanism of Auth.
+	AuthFallbacks []SASLClient
+
+	// AltNicknames are the nicknames to try, in order, when Nickname is
+	// in use during registration.
+	AltNicknames []string
+
+	// CTCPVersion is the reply to CTCP VERSION requests.  VERSION requests
+	// are not replied to if it is empty.
+	CTCPVersion string
+
+	// IgnoreCTCP reports whether CTCP requests of user, sent to channel
+	// (empty for queries), must not be replied to, if set.
+	IgnoreCTCP func(user *Prefix, channel string) bool
+
+	// Conn is the connection behind the out channel, if any, to report
+	// the number of messages waiting to be sent and the lag.
+	Conn *Conn
 }
 
 type Session struct {
 	out          chan<- Message
+	conn         *Conn
 	closed       bool
 	registered   bool
 	typings      *Typings               // incoming typing notifications.
@@ -121,6 +115,16 @@ type Session struct {
 	netID  string
 	auth   SASLClient
 
+	away bool // whether we are marked as away.
+
+	wantedNick string   // the nickname we would like to have.
+	altNicks   []string // nicknames to try next during registration.
+	regainNick string   // nickname monitored to get it back, if any.
+
+	authFallbacks []SASLClient    // SASL mechanisms to try next.
+	authBuf       strings.Builder // incoming AUTHENTICATE chunks.
+	saslMechs     []string        // mechanisms listed in RPL_SASLMECHS.
+
 	availableCaps map[string]string
 	enabledCaps   map[string]struct{}
 
@@ -144,20 +148,41 @@ type Session struct {
 	searchBatch    SearchEvent             // search batch being processed.
 	monitors       map[string]struct{}     // set of users we want to monitor (and keep even if they are disconnected).
 
+	bouncerNetworks map[string]map[string]string // attributes of the networks of the bouncer, by ID.
+
+	labeling     bool                    // whether messages are being sent from Labeled.
+	labelOrigin  string                  // origin given to Labeled.
+	nextLabel    int                     // label of the next labeled message.
+	labels       map[string]string       // origin of the labeled messages waiting for a reply, by label.
+	labelBatches map[string]LabeledEvent // labeled-response batches being processed, by batch ID.
+
+	whoisReplies map[string]WhoisEvent // WHOIS replies being collected, by casemapped nick.
+
+	mlBatches   map[string]multilineBatch // multiline batches being received, by batch ID.
+	nextBatchID int                       // ID of the next batch we send.
+
+	ctcpVersion string        // reply to CTCP VERSION requests.
+	ctcpLimit   *rate.Limiter // limits automatic replies to CTCP requests.
+	ignoreCTCP  func(user *Prefix, channel string) bool
+
 	pendingChannels map[string]time.Time // set of join requests stamps for channels.
 }
 
 func NewSession(out chan<- Message, params SessionParams) *Session {
 	s := &Session{
 		out:             out,
+		conn:            params.Conn,
 		typings:         NewTypings(),
 		typingStamps:    map[string]typingStamp{},
 		nick:            params.Nickname,
 		nickCf:          CasemapASCII(params.Nickname),
+		wantedNick:      params.Nickname,
+		altNicks:        params.AltNicknames,
 		user:            params.Username,
 		real:            params.RealName,
 		netID:           params.NetID,
 		auth:            params.Auth,
+		authFallbacks:   params.AuthFallbacks,
 		availableCaps:   map[string]string{},
 		enabledCaps:     map[string]struct{}{},
 		casemap:         CasemapRFC1459,
@@ -171,6 +196,14 @@ func NewSession(out chan<- Message, params SessionParams) *Session {
 		chBatches:       map[string]HistoryEvent{},
 		chReqs:          map[string]struct{}{},
 		monitors:        map[string]struct{}{},
+		bouncerNetworks: map[string]map[string]string{},
+		labels:          map[string]string{},
+		labelBatches:    map[string]LabeledEvent{},
+		whoisReplies:    map[string]WhoisEvent{},
+		mlBatches:       map[string]multilineBatch{},
+		ctcpVersion:     params.CTCPVersion,
+		ctcpLimit:       rate.NewLimiter(rate.Every(2*time.Second), 3),
+		ignoreCTCP:      params.IgnoreCTCP,
 		pendingChannels: map[string]time.Time{},
 	}
 
@@ -180,14 +213,14 @@ func NewSession(out chan<- Message, params SessionParams) *Session {
 	}
 	s.out <- NewMessage("NICK", s.nick)
 	s.out <- NewMessage("USER", s.user, "0", "*", s.real)
-	if s.auth != nil && s.auth.Early() {
+	if s.auth != nil && s.auth.Early() && len(s.authFallbacks) == 0 {
 		h := s.auth.Handshake()
 		s.out <- NewMessage("AUTHENTICATE", h)
 		res, err := s.auth.Respond("+")
 		if err != nil {
 			s.out <- NewMessage("AUTHENTICATE", "*")
 		} else {
-			s.out <- NewMessage("AUTHENTICATE", res)
+			s.authenticate(res)
 		}
 		s.auth = nil
 	}
@@ -205,9 +238,24 @@ func (s *Session) Close() {
 	}
 	s.closed = true
 	s.typings.Close()
+	// Replies to labeled messages will never come.
+	s.labels = map[string]string{}
+	s.labelBatches = map[string]LabeledEvent{}
 	close(s.out)
 }
 
+// Pending returns the number of messages waiting to be sent because of flood
+// control.
+func (s *Session) Pending() int {
+	return s.conn.Pending()
+}
+
+// Lag returns the lag measured with the PINGs sent when the connection is
+// idle, or 0 if unknown.
+func (s *Session) Lag() time.Duration {
+	return s.conn.Lag()
+}
+
 // HasCapability reports whether the given capability has been negotiated
 // successfully.
 func (s *Session) HasCapability(capability string) bool {
@@ -215,6 +263,16 @@ func (s *Session) HasCapability(capability string) bool {
 	return ok
 }
 
+// Registered reports whether the registration of the connection is complete.
+func (s *Session) Registered() bool {
+	return s.registered
+}
+
+// Away reports whether we are marked as away.
+func (s *Session) Away() bool {
+	return s.away
+}
+
 func (s *Session) Nick() string {
 	return s.nick
 }
@@ -328,26 +386,59 @@ func (s *Session) Topic(channel string) (topic string, who *Prefix, at time.Time
 	return
 }
 
+// Labeled calls f, which sends messages to the server.  If the server supports
+// labeled-response, its replies to them are returned in LabeledEvents, along
+// with origin.
+func (s *Session) Labeled(origin string, f func()) {
+	s.labeling = true
+	s.labelOrigin = origin
+	f()
+	s.labeling = false
+}
+
+// send sends msg to the server, with a label if it is sent from Labeled.
+// Messages the server does not reply to, such as PRIVMSG and QUIT, are written
+// to s.out instead, so that their labels are not kept forever.
+func (s *Session) send(msg Message) {
+	if s.labeling && s.HasCapability("labeled-response") {
+		label := strconv.Itoa(s.nextLabel)
+		s.nextLabel++
+		s.labels[label] = s.labelOrigin
+		msg = msg.WithTag("label", label)
+	}
+	s.out <- msg
+}
+
 func (s *Session) SendRaw(raw string) {
-	s.out <- NewMessage(raw)
+	s.send(NewMessage(raw))
 }
 
 func (s *Session) Join(channel, key string) {
 	channelCf := s.Casemap(channel)
 	s.pendingChannels[channelCf] = time.Now()
 	if key == "" {
-		s.out <- NewMessage("JOIN", channel)
+		s.send(NewMessage("JOIN", channel))
 	} else {
-		s.out <- NewMessage("JOIN", channel, key)
+		s.send(NewMessage("JOIN", channel, key))
 	}
 }
 
 func (s *Session) Part(channel, reason string) {
-	s.out <- NewMessage("PART", channel, reason)
+	s.send(NewMessage("PART", channel, reason))
+}
+
+// SetAway marks us as away with the given message, or as present if it is
+// empty.
+func (s *Session) SetAway(message string) {
+	if message == "" {
+		s.send(NewMessage("AWAY"))
+	} else {
+		s.send(NewMessage("AWAY", message))
+	}
 }
 
 func (s *Session) ChangeTopic(channel, topic string) {
-	s.out <- NewMessage("TOPIC", channel, topic)
+	s.send(NewMessage("TOPIC", channel, topic))
 }
 
 func (s *Session) Quit(reason string) {
@@ -355,12 +446,42 @@ func (s *Session) Quit(reason string) {
 }
 
 func (s *Session) ChangeNick(nick string) {
-	s.out <- NewMessage("NICK", nick)
+	// The user picked a nickname, stop trying to get the former one back.
+	s.wantedNick = nick
+	s.stopRegain()
+	s.send(NewMessage("NICK", nick))
+}
+
+// BouncerNetworks returns the networks of the bouncer, sorted by ID.
+func (s *Session) BouncerNetworks() []BouncerNetwork {
+	networks := make([]BouncerNetwork, 0, len(s.bouncerNetworks))
+	for id, attrs := range s.bouncerNetworks {
+		networks = append(networks, BouncerNetwork{
+			ID:    id,
+			Attrs: attrs,
+		})
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].ID < networks[j].ID
+	})
+	return networks
+}
+
+func (s *Session) AddNetwork(attrs map[string]string) {
+	s.send(NewMessage("BOUNCER", "ADDNETWORK", formatTags(attrs)))
+}
+
+func (s *Session) ChangeNetwork(id string, attrs map[string]string) {
+	s.send(NewMessage("BOUNCER", "CHANGENETWORK", id, formatTags(attrs)))
+}
+
+func (s *Session) DeleteNetwork(id string) {
+	s.send(NewMessage("BOUNCER", "DELNETWORK", id))
 }
 
 func (s *Session) ChangeMode(channel, flags string, args []string) {
 	args = append([]string{channel, flags}, args...)
-	s.out <- NewMessage("MODE", args...)
+	s.send(NewMessage("MODE", args...))
 }
 
 func (s *Session) Search(target, text string) {
@@ -395,6 +516,26 @@ func splitChunks(s string, chunkLen int) (chunks []string) {
 }
 
 func (s *Session) PrivMsg(target, content string) {
+	s.privMsg(target, content, "")
+}
+
+// Reply sends content to target, as a reply to the message of the given
+// msgid.
+func (s *Session) Reply(target, msgID, content string) {
+	s.privMsg(target, content, msgID)
+}
+
+// React sends reaction to the message of the given msgid in target.
+func (s *Session) React(target, msgID, reaction string) {
+	if !s.HasCapability("message-tags") {
+		return
+	}
+	s.out <- NewMessage("TAGMSG", target).
+		WithTag("+draft/reply", msgID).
+		WithTag("+draft/react", reaction)
+}
+
+func (s *Session) privMsg(target, content, replyTo string) {
 	hostLen := len(s.host)
 	if hostLen == 0 {
 		hostLen = len("255.255.255.255")
@@ -405,14 +546,113 @@ func (s *Session) PrivMsg(target, content string) {
 		len(s.user) -
 		hostLen -
 		len(target)
-	chunks := splitChunks(content, maxMessageLen)
-	for _, chunk := range chunks {
-		s.out <- NewMessage("PRIVMSG", target, chunk)
+	lines := strings.Split(content, "\n")
+	maxBytes, maxLines, multiline := s.multilineLimits()
+	if multiline && (1 < len(lines) || maxMessageLen < len(content)) {
+		var parts []multilinePart
+		for _, line := range lines {
+			chunks := splitChunks(line, maxMessageLen)
+			if len(chunks) == 0 {
+				chunks = []string{""}
+			}
+			for i, chunk := range chunks {
+				parts = append(parts, multilinePart{
+					text:   chunk,
+					concat: i != 0,
+				})
+			}
+		}
+		for len(parts) != 0 {
+			n := multilineBatchLen(parts, maxBytes, maxLines)
+			s.sendMultiline(target, replyTo, parts[:n])
+			parts = parts[n:]
+		}
+	} else {
+		for _, line := range lines {
+			for _, chunk := range splitChunks(line, maxMessageLen) {
+				msg := NewMessage("PRIVMSG", target, chunk)
+				if replyTo != "" && s.HasCapability("message-tags") {
+					msg = msg.WithTag("+draft/reply", replyTo)
+				}
+				s.out <- msg
+			}
+		}
 	}
 	targetCf := s.Casemap(target)
 	delete(s.typingStamps, targetCf)
 }
 
+// multilineLimits returns the maximum number of bytes and lines of multiline
+// batches (0 if unlimited), and false if they cannot be sent.
+func (s *Session) multilineLimits() (maxBytes, maxLines int, ok bool) {
+	if !s.HasCapability("draft/multiline") || !s.HasCapability("batch") {
+		return 0, 0, false
+	}
+	for _, param := range strings.Split(s.availableCaps["draft/multiline"], ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 0 {
+			continue
+		}
+		switch kv[0] {
+		case "max-bytes":
+			maxBytes = n
+		case "max-lines":
+			maxLines = n
+		}
+	}
+	return maxBytes, maxLines, true
+}
+
+// multilinePart is a PRIVMSG of a multiline batch.
+type multilinePart struct {
+	text   string
+	concat bool // whether text continues the previous part without a newline.
+}
+
+// multilineBatchLen returns how many of parts fit in a single batch.
+func multilineBatchLen(parts []multilinePart, maxBytes, maxLines int) int {
+	n, size := 0, 0
+	for n < len(parts) && (maxLines == 0 || n < maxLines) {
+		partSize := len(parts[n].text)
+		if n != 0 && !parts[n].concat {
+			partSize++ // the newline
+		}
+		if maxBytes != 0 && n != 0 && maxBytes < size+partSize {
+			break
+		}
+		size += partSize
+		n++
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// sendMultiline sends parts to target as a single multiline batch.
+func (s *Session) sendMultiline(target, replyTo string, parts []multilinePart) {
+	id := strconv.Itoa(s.nextBatchID)
+	s.nextBatchID++
+
+	start := NewMessage("BATCH", "+"+id, "draft/multiline", target)
+	if replyTo != "" {
+		start = start.WithTag("+draft/reply", replyTo)
+	}
+	s.out <- start
+	for i, part := range parts {
+		msg := NewMessage("PRIVMSG", target, part.text).WithTag("batch", id)
+		if i != 0 && part.concat {
+			msg = msg.WithTag("draft/multiline-concat", "")
+		}
+		s.out <- msg
+	}
+	s.out <- NewMessage("BATCH", "-"+id)
+}
+
 func (s *Session) Typing(target string) {
 	if !s.HasCapability("message-tags") {
 		return
@@ -560,15 +800,24 @@ func (s *Session) NewHistoryRequest(target string) *HistoryRequest {
 	}
 }
 
+func (s *Session) Whois(nick string) {
+	s.send(NewMessage("WHOIS", nick))
+}
+
+// CTCP sends a CTCP request to target.
+func (s *Session) CTCP(target, command, params string) {
+	s.out <- NewMessage("PRIVMSG", target, FormatCTCP(strings.ToUpper(command), params))
+}
+
 func (s *Session) Invite(nick, channel string) {
-	s.out <- NewMessage("INVITE", nick, channel)
+	s.send(NewMessage("INVITE", nick, channel))
 }
 
 func (s *Session) Kick(nick, channel, comment string) {
 	if comment == "" {
-		s.out <- NewMessage("KICK", channel, nick)
+		s.send(NewMessage("KICK", channel, nick))
 	} else {
-		s.out <- NewMessage("KICK", channel, nick, comment)
+		s.send(NewMessage("KICK", channel, nick, comment))
 	}
 }
 
@@ -588,7 +837,16 @@ func (s *Session) handleUnregistered(msg Message) (Event, error) {
 			return nil, err
 		}
 
-		s.out <- NewMessage("NICK", nick+"_")
+		next := nick + "_"
+		if len(s.altNicks) != 0 {
+			next = s.altNicks[0]
+			s.altNicks = s.altNicks[1:]
+		}
+		s.out <- NewMessage("NICK", next)
+		return NickInUseEvent{
+			Nick: nick,
+			Next: next,
+		}, nil
 	case rplSaslsuccess:
 		if s.auth != nil {
 			s.endRegistration()
@@ -600,8 +858,50 @@ func (s *Session) handleUnregistered(msg Message) (Event, error) {
 }
 
 func (s *Session) handleRegistered(msg Message) (Event, error) {
+	// Multiline batches are handled first, so that the merged message is
+	// processed as if it had been sent without a batch.
+	if msg.Command == "BATCH" && 2 <= len(msg.Params) && strings.HasPrefix(msg.Params[0], "+") && msg.Params[1] == "draft/multiline" {
+		s.mlBatches[msg.Params[0][1:]] = multilineBatch{start: msg}
+		return nil, nil
+	}
+	if msg.Command == "BATCH" && 1 <= len(msg.Params) && strings.HasPrefix(msg.Params[0], "-") {
+		if b, ok := s.mlBatches[msg.Params[0][1:]]; ok {
+			delete(s.mlBatches, msg.Params[0][1:])
+			if b.command == "" {
+				return nil, nil
+			}
+			msg = b.message()
+		}
+	}
+	if id, ok := msg.Tags["batch"]; ok {
+		if b, ok := s.mlBatches[id]; ok {
+			var content string
+			if err := msg.ParseParams(nil, &content); err != nil {
+				return nil, err
+			}
+			if b.command == "" {
+				b.command = msg.Command
+				b.prefix = msg.Prefix
+			} else if _, ok := msg.Tags["draft/multiline-concat"]; !ok {
+				b.content += "\n"
+			}
+			b.content += content
+			s.mlBatches[id] = b
+			return nil, nil
+		}
+	}
 	if id, ok := msg.Tags["batch"]; ok {
-		if id == s.targetsBatchID {
+		if b, ok := s.labelBatches[id]; ok {
+			ev, err := s.handleMessageRegistered(msg, false)
+			if err != nil {
+				return nil, err
+			}
+			if ev != nil {
+				b.Events = append(b.Events, ev)
+				s.labelBatches[id] = b
+			}
+			return nil, nil
+		} else if id == s.targetsBatchID {
 			var target, timestamp string
 			if err := msg.ParseParams(nil, &target, &timestamp); err != nil {
 				return nil, err
@@ -634,6 +934,26 @@ func (s *Session) handleRegistered(msg Message) (Event, error) {
 			}
 		}
 	}
+	if label, ok := msg.Tags["label"]; ok && msg.Command != "BATCH" {
+		if origin, ok := s.labels[label]; ok {
+			// Single reply, or ACK when there is none.
+			delete(s.labels, label)
+			ev := LabeledEvent{
+				Label:  label,
+				Origin: origin,
+			}
+			if msg.Command != "ACK" {
+				reply, err := s.handleMessageRegistered(msg, false)
+				if err != nil {
+					return nil, err
+				}
+				if reply != nil {
+					ev.Events = []Event{reply}
+				}
+			}
+			return ev, nil
+		}
+	}
 	return s.handleMessageRegistered(msg, false)
 }
 
@@ -649,11 +969,25 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			return nil, err
 		}
 
-		res, err := s.auth.Respond(payload)
+		// Payloads of 400 bytes or more are split in 400-byte chunks,
+		// the last one being shorter or "+".
+		if payload != "+" {
+			s.authBuf.WriteString(payload)
+		}
+		if len(payload) == 400 {
+			break
+		}
+		challenge := s.authBuf.String()
+		s.authBuf.Reset()
+		if challenge == "" {
+			challenge = "+"
+		}
+
+		res, err := s.auth.Respond(challenge)
 		if err != nil {
 			s.out <- NewMessage("AUTHENTICATE", "*")
 		} else {
-			s.out <- NewMessage("AUTHENTICATE", res)
+			s.authenticate(res)
 		}
 	case rplLoggedin:
 		var nuh string
@@ -664,8 +998,42 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		prefix := ParsePrefix(nuh)
 		s.user = prefix.User
 		s.host = prefix.Host
-	case errNicklocked, errSaslfail, errSasltoolong, errSaslaborted, errSaslalready, rplSaslmechs:
+	case rplSaslmechs:
+		var mechs string
+		if err := msg.ParseParams(nil, &mechs); err != nil {
+			return nil, err
+		}
+		// The server follows up with ERR_SASLFAIL, where the next
+		// supported mechanism is tried.
+		s.saslMechs = saslMechanisms(mechs)
+	case errSaslfail:
+		if s.auth != nil && s.saslMechs != nil && !hasMechanism(s.saslMechs, s.auth.Handshake()) {
+			mechs := s.saslMechs
+			s.saslMechs = nil
+			if s.nextAuth(mechs) {
+				s.out <- NewMessage("AUTHENTICATE", s.auth.Handshake())
+				return nil, nil
+			}
+			s.auth = nil
+			s.endRegistration()
+			return ErrorEvent{
+				Severity: SeverityFail,
+				Code:     rplSaslmechs,
+				Message:  fmt.Sprintf("Registration failed: no supported SASL mechanism (the server supports %s)", strings.Join(mechs, ", ")),
+			}, nil
+		}
+		if s.auth != nil {
+			s.auth = nil
+			s.endRegistration()
+		}
+		return ErrorEvent{
+			Severity: SeverityFail,
+			Code:     msg.Command,
+			Message:  fmt.Sprintf("Registration failed: %s", strings.Join(msg.Params[1:], " ")),
+		}, nil
+	case errNicklocked, errSasltoolong, errSaslaborted, errSaslalready:
 		if s.auth != nil {
+			s.auth = nil
 			s.endRegistration()
 		}
 		return ErrorEvent{
@@ -691,6 +1059,12 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			return nil, msg.errNotEnoughParams(3)
 		}
 		s.updateFeatures(msg.Params[1 : len(msg.Params)-1])
+		if s.monitor && s.regainNick == "" && !s.IsMe(s.wantedNick) {
+			// MONITOR replies with the current status of the nickname,
+			// handled in RPL_MONONLINE and RPL_MONOFFLINE.
+			s.regainNick = s.wantedNick
+			s.out <- NewMessage("MONITOR", "+", s.regainNick)
+		}
 		return RegisteredEvent{}, nil
 	case rplWhoreply:
 		var nick, host, flags, username string
@@ -711,13 +1085,50 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		}
 	case rplEndofwho:
 		// do nothing
+	case rplNowaway, rplUnaway:
+		s.away = msg.Command == rplNowaway
+		if u, ok := s.users[s.nickCf]; ok {
+			u.Away = s.away
+		}
+		return SelfAwayEvent{
+			Away: s.away,
+		}, nil
+	case rplWhoisuser, rplWhoisserver, rplWhoisoperator, rplWhoisidle, rplWhoischannels, rplWhoisaccount, rplEndofwhois:
+		return s.handleWhois(msg)
+	case rplAway:
+		var nick, message string
+		if err := msg.ParseParams(nil, &nick, &message); err != nil {
+			return nil, err
+		}
+		if _, ok := s.whoisReplies[s.Casemap(nick)]; ok {
+			return s.handleWhois(msg)
+		}
+		return ErrorEvent{
+			Severity: SeverityNote,
+			Code:     msg.Command,
+			Message:  fmt.Sprintf("%s is away: %s", nick, message),
+		}, nil
 	case "CAP":
 		var subcommand, caps string
 		if err := msg.ParseParams(nil, &subcommand, &caps); err != nil {
 			return nil, err
 		}
 
+		if caps == "*" && len(msg.Params) > 3 {
+			// multiline reply: CAP <nick> LS * :<caps>
+			caps = msg.Params[3]
+		}
+
+		var sts *STSEvent
 		switch subcommand {
+		case "LS":
+			for _, c := range ParseCaps(caps) {
+				s.availableCaps[c.Name] = c.Value
+				if c.Name == "sts" {
+					ev := ParseSTS(c.Value)
+					sts = &ev
+				}
+			}
 		case "ACK":
 			for _, c := range ParseCaps(caps) {
 				if c.Enable {
@@ -727,6 +1138,15 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 				}
 
 				if s.auth != nil && c.Name == "sasl" {
+					if !s.nextAuth(saslMechanisms(s.availableCaps["sasl"])) {
+						s.auth = nil
+						s.endRegistration()
+						return ErrorEvent{
+							Severity: SeverityFail,
+							Code:     "CAP",
+							Message:  fmt.Sprintf("Registration failed: no supported SASL mechanism (the server supports %s)", strings.ReplaceAll(s.availableCaps["sasl"], ",", ", ")),
+						}, nil
+					}
 					h := s.auth.Handshake()
 					s.out <- NewMessage("AUTHENTICATE", h)
 				} else if len(s.channels) != 0 && c.Name == "multi-prefix" {
@@ -737,10 +1157,19 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 				}
 			}
 		case "NAK":
-			// do nothing
+			for _, c := range ParseCaps(caps) {
+				if s.auth != nil && c.Name == "sasl" {
+					s.auth = nil
+					s.endRegistration()
+				}
+			}
 		case "NEW":
 			for _, c := range ParseCaps(caps) {
 				s.availableCaps[c.Name] = c.Value
+				if c.Name == "sts" {
+					ev := ParseSTS(c.Value)
+					sts = &ev
+				}
 				if _, ok := SupportedCapabilities[c.Name]; !ok {
 					continue
 				}
@@ -755,6 +1184,9 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 				delete(s.enabledCaps, c.Name)
 			}
 		}
+		if sts != nil {
+			return *sts, nil
+		}
 	case "JOIN":
 		if msg.Prefix == nil {
 			return nil, errMissingPrefix
@@ -768,6 +1200,7 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		if playback {
 			return UserJoinEvent{
 				User:    msg.Prefix.Name,
+				Prefix:  msg.Prefix.Copy(),
 				Channel: channel,
 				Time:    msg.TimeOrNow(),
 			}, nil
@@ -794,6 +1227,7 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			c.Members[s.users[nickCf]] = ""
 			return UserJoinEvent{
 				User:    msg.Prefix.Name,
+				Prefix:  msg.Prefix.Copy(),
 				Channel: c.Name,
 				Time:    msg.TimeOrNow(),
 			}, nil
@@ -811,6 +1245,7 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		if playback {
 			return UserPartEvent{
 				User:    msg.Prefix.Name,
+				Prefix:  msg.Prefix.Copy(),
 				Channel: channel,
 				Time:    msg.TimeOrNow(),
 			}, nil
@@ -836,6 +1271,7 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 				s.typings.Done(channelCf, nickCf)
 				return UserPartEvent{
 					User:    u.Name.Name,
+					Prefix:  msg.Prefix.Copy(),
 					Channel: c.Name,
 					Time:    msg.TimeOrNow(),
 				}, nil
@@ -850,6 +1286,7 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		if playback {
 			return UserPartEvent{
 				User:    nick,
+				Prefix:  &Prefix{Name: nick},
 				Channel: channel,
 				Time:    msg.TimeOrNow(),
 			}, nil
@@ -875,6 +1312,7 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 				s.typings.Done(channelCf, nickCf)
 				return UserPartEvent{
 					User:    nick,
+					Prefix:  u.Name.Copy(),
 					Channel: c.Name,
 					Time:    msg.TimeOrNow(),
 				}, nil
@@ -887,8 +1325,9 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 
 		if playback {
 			return UserQuitEvent{
-				User: msg.Prefix.Name,
-				Time: msg.TimeOrNow(),
+				User:   msg.Prefix.Name,
+				Prefix: msg.Prefix.Copy(),
+				Time:   msg.TimeOrNow(),
 			}, nil
 		}
 
@@ -907,11 +1346,13 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			}
 			return UserQuitEvent{
 				User:     u.Name.Name,
+				Prefix:   msg.Prefix.Copy(),
 				Channels: channels,
 				Time:     msg.TimeOrNow(),
 			}, nil
 		}
 	case rplMononline:
+		var ev Event
 		for _, target := range strings.Split(msg.Params[1], ",") {
 			prefix := ParsePrefix(target)
 			if prefix == nil {
@@ -919,6 +1360,12 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			}
 			nickCf := s.casemap(prefix.Name)
 
+			if s.regainNick != "" && nickCf == s.casemap(s.regainNick) {
+				ev = NickRegainEvent{
+					Nick: s.regainNick,
+				}
+			}
+
 			if _, ok := s.monitors[nickCf]; ok {
 				u, ok := s.users[nickCf]
 				if !ok {
@@ -935,7 +1382,11 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 				}
 			}
 		}
+		if ev != nil {
+			return ev, nil
+		}
 	case rplMonoffline:
+		var ev Event
 		for _, target := range strings.Split(msg.Params[1], ",") {
 			prefix := ParsePrefix(target)
 			if prefix == nil {
@@ -943,6 +1394,14 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			}
 			nickCf := s.casemap(prefix.Name)
 
+			if s.regainNick != "" && nickCf == s.casemap(s.regainNick) {
+				s.out <- NewMessage("NICK", s.regainNick)
+				ev = NickRegainEvent{
+					Nick:      s.regainNick,
+					Available: true,
+				}
+			}
+
 			if _, ok := s.monitors[nickCf]; ok {
 				u, ok := s.users[nickCf]
 				if !ok {
@@ -959,6 +1418,9 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 				}
 			}
 		}
+		if ev != nil {
+			return ev, nil
+		}
 	case rplNamreply:
 		var channel, names string
 		if err := msg.ParseParams(nil, nil, &channel, &names); err != nil {
@@ -1166,12 +1628,18 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			return nil, errMissingPrefix
 		}
 
-		var target string
-		if err := msg.ParseParams(&target); err != nil {
+		var target, content string
+		if err := msg.ParseParams(&target, &content); err != nil {
 			return nil, err
 		}
 
+		command, params, isCTCP := ParseCTCP(content)
+		isCTCP = isCTCP && command != "ACTION"
+
 		if playback {
+			if isCTCP {
+				return s.newCTCPEvent(msg, command, params)
+			}
 			return s.newMessageEvent(msg)
 		}
 
@@ -1179,12 +1647,18 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		nickCf := s.casemap(msg.Prefix.Name)
 		s.typings.Done(targetCf, nickCf)
 
-		return s.newMessageEvent(msg)
-	case "TAGMSG":
-		if playback {
-			return nil, nil
+		if isCTCP {
+			if msg.Command == "PRIVMSG" && !s.IsMe(msg.Prefix.Name) && !s.isCTCPIgnored(msg.Prefix, target) {
+				reply, ok := s.ctcpReply(command, params)
+				if ok && s.ctcpLimit.Allow() {
+					s.out <- NewMessage("NOTICE", msg.Prefix.Name, FormatCTCP(command, reply))
+				}
+			}
+			return s.newCTCPEvent(msg, command, params)
 		}
 
+		return s.newMessageEvent(msg)
+	case "TAGMSG":
 		if msg.Prefix == nil {
 			return nil, errMissingPrefix
 		}
@@ -1194,6 +1668,28 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			return nil, err
 		}
 
+		reaction := msg.Tags["+draft/react"]
+		replyTo := msg.Tags["+draft/reply"]
+		if reaction != "" && replyTo != "" {
+			ev := ReactionEvent{
+				User:     msg.Prefix.Name,
+				Prefix:   msg.Prefix.Copy(),
+				Target:   target,
+				ReplyTo:  replyTo,
+				Reaction: reaction,
+				Time:     msg.TimeOrNow(),
+			}
+			if c, ok := s.channels[s.Casemap(target)]; ok {
+				ev.Target = c.Name
+				ev.TargetIsChannel = true
+			}
+			return ev, nil
+		}
+
+		if playback {
+			return nil, nil
+		}
+
 		targetCf := s.casemap(target)
 		nickCf := s.casemap(msg.Prefix.Name)
 
@@ -1242,6 +1738,15 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			case "soju.im/search":
 				s.searchBatchID = id
 				s.searchBatch = SearchEvent{}
+			case "labeled-response":
+				label := msg.Tags["label"]
+				if origin, ok := s.labels[label]; ok {
+					delete(s.labels, label)
+					s.labelBatches[id] = LabeledEvent{
+						Label:  label,
+						Origin: origin,
+					}
+				}
 			}
 		} else {
 			if b, ok := s.chBatches[id]; ok {
@@ -1255,6 +1760,9 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			} else if s.searchBatchID == id {
 				s.searchBatchID = ""
 				return s.searchBatch, nil
+			} else if b, ok := s.labelBatches[id]; ok {
+				delete(s.labelBatches, id)
+				return b, nil
 			}
 		}
 	case "NICK":
@@ -1290,6 +1798,9 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 		if s.IsMe(msg.Prefix.Name) {
 			s.nick = newNick
 			s.nickCf = newNickCf
+			if s.regainNick != "" && s.IsMe(s.regainNick) {
+				s.stopRegain()
+			}
 			return SelfNickEvent{
 				FormerNick: msg.Prefix.Name,
 			}, nil
@@ -1328,10 +1839,31 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 			break
 		}
 		id := msg.Params[1]
-		attrs := parseTags(msg.Params[2])
+		if msg.Params[2] == "*" {
+			delete(s.bouncerNetworks, id)
+			return BouncerNetworkEvent{
+				ID:      id,
+				Deleted: true,
+			}, nil
+		}
+		attrs, ok := s.bouncerNetworks[id]
+		if !ok {
+			attrs = map[string]string{}
+			s.bouncerNetworks[id] = attrs
+		}
+		// Updates only contain the attributes that changed, removed
+		// ones having an empty value.
+		for k, v := range parseTags(msg.Params[2]) {
+			if v == "" {
+				delete(attrs, k)
+			} else {
+				attrs[k] = v
+			}
+		}
 		return BouncerNetworkEvent{
-			ID:   id,
-			Name: attrs["name"],
+			ID:    id,
+			Name:  attrs["name"],
+			State: attrs["state"],
 		}, nil
 	case "PING":
 		var payload string
@@ -1384,6 +1916,80 @@ func (s *Session) handleMessageRegistered(msg Message, playback bool) (Event, er
 	return nil, nil
 }
 
+// handleWhois collects the replies to WHOIS, and returns them in a WhoisEvent
+// on RPL_ENDOFWHOIS.
+func (s *Session) handleWhois(msg Message) (Event, error) {
+	var nick string
+	if err := msg.ParseParams(nil, &nick); err != nil {
+		return nil, err
+	}
+	nickCf := s.Casemap(nick)
+	w, ok := s.whoisReplies[nickCf]
+	if !ok {
+		w = WhoisEvent{Nick: nick}
+	}
+
+	switch msg.Command {
+	case rplWhoisuser:
+		if err := msg.ParseParams(nil, nil, &w.User, &w.Host, nil, &w.RealName); err != nil {
+			return nil, err
+		}
+	case rplWhoisserver:
+		if err := msg.ParseParams(nil, nil, &w.Server, &w.ServerInfo); err != nil {
+			return nil, err
+		}
+	case rplWhoisoperator:
+		w.Operator = true
+	case rplWhoisidle:
+		var idle string
+		if err := msg.ParseParams(nil, nil, &idle); err != nil {
+			return nil, err
+		}
+		if seconds, err := strconv.Atoi(idle); err == nil {
+			w.Idle = time.Duration(seconds) * time.Second
+		}
+		if len(msg.Params) > 4 {
+			if signon, err := strconv.ParseInt(msg.Params[3], 10, 64); err == nil {
+				w.Signon = time.Unix(signon, 0)
+			}
+		}
+	case rplWhoischannels:
+		var channels string
+		if err := msg.ParseParams(nil, nil, &channels); err != nil {
+			return nil, err
+		}
+		for _, channel := range strings.Fields(channels) {
+			i := strings.IndexFunc(channel, func(r rune) bool {
+				return !strings.ContainsRune(s.prefixSymbols, r)
+			})
+			if i < 0 {
+				continue
+			}
+			w.Channels = append(w.Channels, WhoisChannel{
+				Name:       channel[i:],
+				PowerLevel: channel[:i],
+			})
+		}
+	case rplWhoisaccount:
+		if err := msg.ParseParams(nil, nil, &w.Account); err != nil {
+			return nil, err
+		}
+	case rplAway:
+		if err := msg.ParseParams(nil, nil, &w.Away); err != nil {
+			return nil, err
+		}
+	case rplEndofwhois:
+		delete(s.whoisReplies, nickCf)
+		if !ok {
+			// Only errors were sent, such as ERR_NOSUCHNICK.
+			return nil, nil
+		}
+		return w, nil
+	}
+	s.whoisReplies[nickCf] = w
+	return nil, nil
+}
+
 func (s *Session) newMessageEvent(msg Message) (ev MessageEvent, err error) {
 	if msg.Prefix == nil {
 		return ev, errMissingPrefix
@@ -1397,9 +2003,12 @@ func (s *Session) newMessageEvent(msg Message) (ev MessageEvent, err error) {
 	ev = MessageEvent{
 		User:    msg.Prefix.Name, // TODO correctly casemap
 		Target:  target,          // TODO correctly casemap
+		Prefix:  msg.Prefix.Copy(),
 		Command: msg.Command,
 		Content: content,
 		Time:    msg.TimeOrNow(),
+		ID:      msg.Tags["msgid"],
+		ReplyTo: msg.Tags["+draft/reply"],
 	}
 
 	targetCf := s.Casemap(target)
@@ -1411,6 +2020,34 @@ func (s *Session) newMessageEvent(msg Message) (ev MessageEvent, err error) {
 	return ev, nil
 }
 
+func (s *Session) newCTCPEvent(msg Message, command, params string) (ev CTCPEvent, err error) {
+	var target string
+	if err := msg.ParseParams(&target); err != nil {
+		return ev, err
+	}
+
+	return CTCPEvent{
+		User:    msg.Prefix.Name,
+		Prefix:  msg.Prefix.Copy(),
+		Target:  target,
+		Command: command,
+		Params:  params,
+		Reply:   msg.Command == "NOTICE",
+		Time:    msg.TimeOrNow(),
+	}, nil
+}
+
+// stopRegain stops monitoring the nickname we wanted to get back.
+func (s *Session) stopRegain() {
+	if s.regainNick == "" {
+		return
+	}
+	if _, ok := s.monitors[s.casemap(s.regainNick)]; !ok {
+		s.out <- NewMessage("MONITOR", "-", s.regainNick)
+	}
+	s.regainNick = ""
+}
+
 func (s *Session) cleanUser(parted *User) {
 	nameCf := s.Casemap(parted.Name.Name)
 	if _, ok := s.monitors[nameCf]; ok {
@@ -1508,6 +2145,45 @@ func (s *Session) updateFeatures(features []string) {
 	}
 }
 
+// authenticate sends an AUTHENTICATE response, split in chunks if needed.
+func (s *Session) authenticate(payload string) {
+	for len(payload) >= 400 {
+		s.out <- NewMessage("AUTHENTICATE", payload[:400])
+		payload = payload[400:]
+	}
+	if payload == "" {
+		payload = "+"
+	}
+	s.out <- NewMessage("AUTHENTICATE", payload)
+}
+
+// nextAuth makes the current SASL mechanism the first one, starting from the
+// current one, supported by the server according to mechs.  An empty mechs
+// means any mechanism is supported.  It returns false if there are none left.
+func (s *Session) nextAuth(mechs []string) bool {
+	for s.auth != nil {
+		if len(mechs) == 0 || hasMechanism(mechs, s.auth.Handshake()) {
+			return true
+		}
+		if len(s.authFallbacks) == 0 {
+			s.auth = nil
+			break
+		}
+		s.auth = s.authFallbacks[0]
+		s.authFallbacks = s.authFallbacks[1:]
+	}
+	return false
+}
+
+func hasMechanism(mechs []string, mech string) bool {
+	for _, m := range mechs {
+		if m == mech {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Session) endRegistration() {
 	if s.registered {
 		return
@@ -1520,3 +2196,30 @@ func (s *Session) endRegistration() {
 		s.out <- NewMessage("BOUNCER", "LISTNETWORKS")
 	}
 }
+
+// multilineBatch is a draft/multiline batch being received.
+type multilineBatch struct {
+	start   Message // the BATCH message that started the batch.
+	command string  // PRIVMSG or NOTICE.
+	prefix  *Prefix
+	content string
+}
+
+// message returns the message made of all the lines of the batch, with the
+// tags of the start of the batch.
+func (b *multilineBatch) message() Message {
+	var target string
+	if 3 <= len(b.start.Params) {
+		target = b.start.Params[2]
+	}
+	prefix := b.prefix
+	if prefix == nil {
+		prefix = b.start.Prefix
+	}
+	return Message{
+		Tags:    b.start.Tags,
+		Prefix:  prefix,
+		Command: b.command,
+		Params:  []string{target, b.content},
+	}
+}
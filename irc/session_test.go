@@ -0,0 +1,349 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSession(t *testing.T, caps string) (*Session, chan Message) {
+	out := make(chan Message, 256)
+	s := NewSession(out, SessionParams{
+		Nickname: "nick",
+		Username: "user",
+		RealName: "real",
+	})
+	handleTestMessage(t, s, ":server CAP * ACK :"+caps)
+	handleTestMessage(t, s, ":server 001 nick :Welcome")
+	for len(out) != 0 {
+		<-out
+	}
+	return s, out
+}
+
+func handleTestMessage(t *testing.T, s *Session, line string) Event {
+	msg, err := ParseMessage(line)
+	if err != nil {
+		t.Fatalf("%q: %v", line, err)
+	}
+	ev, err := s.HandleMessage(msg)
+	if err != nil {
+		t.Fatalf("%q: %v", line, err)
+	}
+	return ev
+}
+
+func TestLabeledResponse(t *testing.T) {
+	s, out := newTestSession(t, "batch labeled-response")
+
+	s.Labeled("#senpai", func() {
+		s.ChangeMode("#senpai", "+o", []string{"nick"})
+		s.ChangeMode("#senpai", "+v", []string{"nick"})
+		s.ChangeMode("#senpai", "+b", []string{"nick"})
+	})
+	var labels []string
+	for len(out) != 0 {
+		msg := <-out
+		label, ok := msg.Tags["label"]
+		if !ok {
+			t.Fatalf("%q: expected a label", msg.String())
+		}
+		labels = append(labels, label)
+	}
+	if len(labels) != 3 || labels[0] == labels[1] || labels[1] == labels[2] {
+		t.Fatalf("expected 3 distinct labels, got %v", labels)
+	}
+
+	ev := handleTestMessage(t, s, "@label="+labels[0]+" :server 482 nick #senpai :You're not channel operator")
+	labeled, ok := ev.(LabeledEvent)
+	if !ok || labeled.Origin != "#senpai" || len(labeled.Events) != 1 {
+		t.Fatalf("single reply: unexpected event %#v", ev)
+	}
+	if _, ok := labeled.Events[0].(ErrorEvent); !ok {
+		t.Errorf("single reply: expected an ErrorEvent, got %#v", labeled.Events[0])
+	}
+
+	for _, line := range []string{
+		"@label=" + labels[1] + " :server BATCH +b labeled-response",
+		"@batch=b :server 482 nick #senpai :You're not channel operator",
+		"@batch=b :server 482 nick #senpai :Still not channel operator",
+	} {
+		if ev := handleTestMessage(t, s, line); ev != nil {
+			t.Fatalf("%q: unexpected event %#v", line, ev)
+		}
+	}
+	ev = handleTestMessage(t, s, ":server BATCH -b")
+	labeled, ok = ev.(LabeledEvent)
+	if !ok || labeled.Origin != "#senpai" || len(labeled.Events) != 2 {
+		t.Fatalf("batch: unexpected event %#v", ev)
+	}
+
+	ev = handleTestMessage(t, s, "@label="+labels[2]+" :server ACK")
+	labeled, ok = ev.(LabeledEvent)
+	if !ok || labeled.Origin != "#senpai" || len(labeled.Events) != 0 {
+		t.Fatalf("ACK: unexpected event %#v", ev)
+	}
+}
+
+func TestLabeledResponseUnsupported(t *testing.T) {
+	s, out := newTestSession(t, "batch")
+
+	s.Labeled("#senpai", func() {
+		s.ChangeMode("#senpai", "+o", []string{"nick"})
+	})
+	msg := <-out
+	if _, ok := msg.Tags["label"]; ok {
+		t.Errorf("%q: unexpected label without labeled-response", msg.String())
+	}
+}
+
+func TestLabeledResponseNoReply(t *testing.T) {
+	s, out := newTestSession(t, "batch labeled-response")
+
+	s.Labeled("#senpai", func() {
+		s.PrivMsg("#senpai", "hello")
+		s.Join("#other", "")
+	})
+	msg := <-out
+	if _, ok := msg.Tags["label"]; ok {
+		t.Errorf("%q: unexpected label on a message without reply", msg.String())
+	}
+	msg = <-out
+	if _, ok := msg.Tags["label"]; !ok {
+		t.Errorf("%q: expected a label", msg.String())
+	}
+	if len(s.labels) != 1 {
+		t.Errorf("expected 1 pending label, got %d", len(s.labels))
+	}
+
+	s.Close()
+	if len(s.labels) != 0 {
+		t.Errorf("expected pending labels to be dropped on close, got %d", len(s.labels))
+	}
+}
+
+func TestWhois(t *testing.T) {
+	s, _ := newTestSession(t, "")
+
+	for _, line := range []string{
+		":server 311 nick Alice alice example.org * :Alice Liddell",
+		":server 319 nick alice :@#senpai +#soju #irc",
+		":server 312 nick alice irc.example.org :Example server",
+		":server 330 nick alice alice_acct :is logged in as",
+		":server 317 nick alice 90 1600000000 :seconds idle, signon time",
+		":server 301 nick alice :Gone fishing",
+	} {
+		if ev := handleTestMessage(t, s, line); ev != nil {
+			t.Fatalf("%q: unexpected event %#v", line, ev)
+		}
+	}
+	ev := handleTestMessage(t, s, ":server 318 nick alice :End of WHOIS list")
+	w, ok := ev.(WhoisEvent)
+	if !ok {
+		t.Fatalf("expected a WhoisEvent, got %#v", ev)
+	}
+	if w.Nick != "Alice" || w.User != "alice" || w.RealName != "Alice Liddell" || w.Account != "alice_acct" || w.Away != "Gone fishing" {
+		t.Errorf("unexpected user information: %#v", w)
+	}
+	if w.Idle != 90*time.Second || w.Signon.Unix() != 1600000000 {
+		t.Errorf("unexpected times: %v, %v", w.Idle, w.Signon)
+	}
+	expected := []WhoisChannel{{"#senpai", "@"}, {"#soju", "+"}, {"#irc", ""}}
+	if len(w.Channels) != len(expected) {
+		t.Fatalf("expected channels %v, got %v", expected, w.Channels)
+	}
+	for i := range expected {
+		if w.Channels[i] != expected[i] {
+			t.Errorf("channel #%d: expected %v, got %v", i, expected[i], w.Channels[i])
+		}
+	}
+
+	if ev := handleTestMessage(t, s, ":server 318 nick bob :End of WHOIS list"); ev != nil {
+		t.Errorf("unexpected event for an unknown nick: %#v", ev)
+	}
+}
+
+func TestCTCP(t *testing.T) {
+	s, out := newTestSession(t, "")
+
+	ev := handleTestMessage(t, s, ":dan!d@host PRIVMSG nick :\x01PING 1234\x01")
+	ctcp, ok := ev.(CTCPEvent)
+	if !ok || ctcp.Command != "PING" || ctcp.Params != "1234" || ctcp.Reply {
+		t.Fatalf("request: unexpected event %#v", ev)
+	}
+	if len(out) != 1 {
+		t.Fatalf("expected a reply, got %d messages", len(out))
+	}
+	if reply := <-out; reply.String() != "NOTICE dan :\x01PING 1234\x01" {
+		t.Errorf("unexpected reply %q", reply.String())
+	}
+
+	ev = handleTestMessage(t, s, ":dan!d@host NOTICE nick :\x01version senpai\x01")
+	ctcp, ok = ev.(CTCPEvent)
+	if !ok || ctcp.Command != "VERSION" || ctcp.Params != "senpai" || !ctcp.Reply {
+		t.Fatalf("reply: unexpected event %#v", ev)
+	}
+
+	ev = handleTestMessage(t, s, ":dan!d@host PRIVMSG nick :\x01ACTION waves\x01")
+	if _, ok := ev.(MessageEvent); !ok {
+		t.Fatalf("action: unexpected event %#v", ev)
+	}
+
+	for i := 0; i < 5; i++ {
+		handleTestMessage(t, s, ":dan!d@host PRIVMSG nick :\x01CLIENTINFO\x01")
+	}
+	if len(out) != 2 {
+		t.Errorf("expected replies to be rate limited, got %d replies", len(out))
+	}
+}
+
+func TestCTCPIgnored(t *testing.T) {
+	out := make(chan Message, 256)
+	s := NewSession(out, SessionParams{
+		Nickname: "nick",
+		Username: "user",
+		RealName: "real",
+		IgnoreCTCP: func(user *Prefix, channel string) bool {
+			return user.Name == "troll" && channel == ""
+		},
+	})
+	handleTestMessage(t, s, ":server 001 nick :Welcome")
+	for len(out) != 0 {
+		<-out
+	}
+
+	ev := handleTestMessage(t, s, ":troll!t@host PRIVMSG nick :\x01VERSION\x01")
+	if _, ok := ev.(CTCPEvent); !ok {
+		t.Fatalf("unexpected event %#v", ev)
+	}
+	if len(out) != 0 {
+		msg := <-out
+		t.Errorf("expected no reply to an ignored user, got %q", msg.String())
+	}
+
+	handleTestMessage(t, s, ":troll!t@host PRIVMSG #senpai :\x01PING 1\x01")
+	handleTestMessage(t, s, ":dan!d@host PRIVMSG nick :\x01PING 2\x01")
+	if len(out) != 2 {
+		t.Errorf("expected replies to others, got %d messages", len(out))
+	}
+}
+
+func TestReplyAndReaction(t *testing.T) {
+	s, out := newTestSession(t, "message-tags")
+
+	ev := handleTestMessage(t, s, "@msgid=2;+draft/reply=1 :dan!d@host PRIVMSG nick :hi")
+	msg, ok := ev.(MessageEvent)
+	if !ok || msg.ID != "2" || msg.ReplyTo != "1" {
+		t.Fatalf("reply: unexpected event %#v", ev)
+	}
+
+	ev = handleTestMessage(t, s, "@+draft/reply=2;+draft/react=+1 :dan!d@host TAGMSG nick")
+	reaction, ok := ev.(ReactionEvent)
+	if !ok || reaction.ReplyTo != "2" || reaction.Reaction != "+1" {
+		t.Fatalf("reaction: unexpected event %#v", ev)
+	}
+
+	s.React("dan", "2", "+1")
+	if sent := <-out; sent.Tags["+draft/reply"] != "2" || sent.Tags["+draft/react"] != "+1" {
+		t.Errorf("unexpected reaction %q", sent.String())
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	s, out := newTestSession(t, "batch draft/multiline")
+	s.availableCaps["draft/multiline"] = "max-bytes=4096,max-lines=2"
+
+	s.PrivMsg("#senpai", "a\nb\nc")
+	var sent []string
+	for len(out) != 0 {
+		msg := <-out
+		sent = append(sent, msg.Tags["batch"]+" "+msg.Command+" "+strings.Join(msg.Params, " "))
+	}
+	expected := []string{
+		" BATCH +0 draft/multiline #senpai",
+		"0 PRIVMSG #senpai a",
+		"0 PRIVMSG #senpai b",
+		" BATCH -0",
+		" BATCH +1 draft/multiline #senpai",
+		"1 PRIVMSG #senpai c",
+		" BATCH -1",
+	}
+	if len(sent) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, sent)
+	}
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("message %d: expected %q, got %q", i, expected[i], sent[i])
+		}
+	}
+
+	handleTestMessage(t, s, ":dan!d@host BATCH +x draft/multiline #senpai")
+	handleTestMessage(t, s, "@batch=x :dan!d@host PRIVMSG #senpai :hello")
+	handleTestMessage(t, s, "@batch=x;draft/multiline-concat :dan!d@host PRIVMSG #senpai :, world")
+	handleTestMessage(t, s, "@batch=x :dan!d@host PRIVMSG #senpai :bye")
+	ev := handleTestMessage(t, s, ":dan!d@host BATCH -x")
+	msg, ok := ev.(MessageEvent)
+	if !ok || msg.User != "dan" || msg.Content != "hello, world\nbye" {
+		t.Errorf("unexpected event %#v", ev)
+	}
+}
+
+func TestMultilineEmptyLine(t *testing.T) {
+	s, out := newTestSession(t, "batch draft/multiline")
+	s.availableCaps["draft/multiline"] = "max-bytes=4096,max-lines=10"
+
+	s.PrivMsg("#senpai", "a\n\nb")
+	var sent []string
+	for len(out) != 0 {
+		msg := <-out
+		batch := msg.Tags["batch"]
+		msg.Tags = nil
+		sent = append(sent, batch+" "+msg.String())
+	}
+	expected := []string{
+		" BATCH +0 draft/multiline #senpai",
+		"0 PRIVMSG #senpai a",
+		"0 PRIVMSG #senpai :",
+		"0 PRIVMSG #senpai b",
+		" BATCH -0",
+	}
+	if len(sent) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, sent)
+	}
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("message %d: expected %q, got %q", i, expected[i], sent[i])
+		}
+	}
+}
+
+func TestMessageStringLineBreak(t *testing.T) {
+	for _, tc := range []struct {
+		msg      Message
+		expected string
+	}{
+		{NewMessage("TOPIC", "#senpai", "x\r\nQUIT :bye"), "TOPIC #senpai x"},
+		{NewMessage("PRIVMSG", "#senpai\nQUIT", "hi"), "PRIVMSG #senpai hi"},
+		{NewMessage("PRIVMSG", "#senpai", "a\x00b c"), "PRIVMSG #senpai a"},
+	} {
+		if actual := tc.msg.String(); actual != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, actual)
+		}
+	}
+}
+
+func TestSTS(t *testing.T) {
+	s, _ := newTestSession(t, "")
+
+	ev := handleTestMessage(t, s, ":server CAP nick LS :batch sts=port=6697,duration=300")
+	sts, ok := ev.(STSEvent)
+	if !ok || sts.Port != "6697" || sts.Duration != 300*time.Second {
+		t.Errorf("unexpected event %#v", ev)
+	}
+
+	ev = handleTestMessage(t, s, ":server CAP nick NEW :sts=port=6697")
+	sts, ok = ev.(STSEvent)
+	if !ok || sts.Port != "6697" || 0 <= sts.Duration {
+		t.Errorf("unexpected event %#v", ev)
+	}
+}
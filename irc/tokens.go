@@ -3,6 +3,7 @@ package irc
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -299,6 +300,15 @@ func (msg Message) WithTag(key, value string) Message {
 	return msg
 }
 
+// cutLine returns p up to its first CR, LF or NUL character, which cannot be
+// sent in a parameter.
+func cutLine(p string) string {
+	if i := strings.IndexAny(p, "\r\n\x00"); i >= 0 {
+		return p[:i]
+	}
+	return p
+}
+
 // IsReply reports whether the message command is a server reply.
 func (msg *Message) IsReply() bool {
 	if len(msg.Command) != 3 {
@@ -313,7 +323,8 @@ func (msg *Message) IsReply() bool {
 }
 
 // String returns the protocol representation of the message, without an ending
-// "\r\n".
+// "\r\n".  Parameters are cut at their first CR, LF or NUL character, so that
+// they cannot end the line and smuggle other messages.
 func (msg *Message) String() string {
 	var sb strings.Builder
 
@@ -334,10 +345,10 @@ func (msg *Message) String() string {
 	if len(msg.Params) != 0 {
 		for _, p := range msg.Params[:len(msg.Params)-1] {
 			sb.WriteRune(' ')
-			sb.WriteString(p)
+			sb.WriteString(cutLine(p))
 		}
-		lastParam := msg.Params[len(msg.Params)-1]
-		if !strings.ContainsRune(lastParam, ' ') && !strings.HasPrefix(lastParam, ":") {
+		lastParam := cutLine(msg.Params[len(msg.Params)-1])
+		if lastParam != "" && !strings.ContainsRune(lastParam, ' ') && !strings.HasPrefix(lastParam, ":") {
 			sb.WriteRune(' ')
 			sb.WriteString(lastParam)
 		} else {
@@ -461,6 +472,28 @@ func ParseCaps(caps string) (diff []Cap) {
 	return
 }
 
+// ParseSTS parses the value of the sts capability.
+func ParseSTS(value string) STSEvent {
+	ev := STSEvent{Duration: -1}
+	for _, kv := range strings.Split(value, ",") {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "port":
+			ev.Port = kv[1]
+		case "duration":
+			seconds, err := strconv.ParseUint(kv[1], 10, 31)
+			if err != nil {
+				continue
+			}
+			ev.Duration = time.Duration(seconds) * time.Second
+		}
+	}
+	return ev
+}
+
 // Member is a token in RPL_NAMREPLY's last parameter.
 type Member struct {
 	PowerLevel   string
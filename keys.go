@@ -0,0 +1,403 @@
+package senpai
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"git.sr.ht/~taiite/senpai/ui"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Key is a key press that can be bound to an action, such as CTRL-N or ALT-1.
+type Key struct {
+	Key  tcell.Key
+	Rune rune // the character typed, for tcell.KeyRune.
+	Mod  tcell.ModMask
+}
+
+// keyOf returns the key pressed in ev, normalized so that it can be compared
+// to the ones returned by ParseKey.
+func keyOf(ev *tcell.EventKey) Key {
+	return normalizeKey(Key{
+		Key:  ev.Key(),
+		Rune: ev.Rune(),
+		Mod:  ev.Modifiers(),
+	})
+}
+
+func normalizeKey(k Key) Key {
+	k.Mod &^= tcell.ModMeta
+	switch {
+	case k.Key == tcell.KeyRune:
+		// The case of the character already tells whether SHIFT is
+		// pressed.
+		k.Mod &^= tcell.ModShift
+	case k.Key == tcell.KeyLF:
+		k.Key = tcell.KeyCR
+	case k.Key == tcell.KeyBackspace:
+		k.Key = tcell.KeyBackspace2
+	}
+	if k.Key < ' ' || k.Key == tcell.KeyBackspace2 {
+		// Control characters are typed with CTRL, or with keys such as
+		// TAB, which terminals do not tell apart.
+		k.Mod &^= tcell.ModCtrl
+	}
+	if k.Key != tcell.KeyRune {
+		k.Rune = 0
+	}
+	return k
+}
+
+// ctrlKeys are the keys typed with CTRL and a character, which tcell names
+// after the key sending the same control character, such as CTRL-M and ENTER.
+var ctrlKeys = map[rune]tcell.Key{
+	'H': tcell.KeyBackspace,
+	'I': tcell.KeyTab,
+	'M': tcell.KeyCR,
+	'[': tcell.KeyEscape,
+}
+
+// keyNames are the names of special keys in key descriptions, in lowercase.
+var keyNames = map[string]tcell.Key{
+	"escape":   tcell.KeyEscape,
+	"pageup":   tcell.KeyPgUp,
+	"pagedown": tcell.KeyPgDn,
+}
+
+func init() {
+	for k, name := range tcell.KeyNames {
+		if !strings.HasPrefix(name, "Ctrl-") {
+			keyNames[strings.ToLower(name)] = k
+		}
+	}
+}
+
+// ParseKey parses a key description, made of modifiers among "ctrl-", "alt-"
+// and "shift-", followed by a character or the name of a special key, such as
+// "ctrl-n", "alt-1", "shift-tab" or "f7".
+func ParseKey(s string) (Key, error) {
+	var k Key
+	desc := s
+	for {
+		lower := strings.ToLower(s)
+		if strings.HasPrefix(lower, "ctrl-") && len(s) > 5 {
+			k.Mod |= tcell.ModCtrl
+			s = s[5:]
+		} else if strings.HasPrefix(lower, "alt-") && len(s) > 4 {
+			k.Mod |= tcell.ModAlt
+			s = s[4:]
+		} else if strings.HasPrefix(lower, "meta-") && len(s) > 5 {
+			k.Mod |= tcell.ModAlt
+			s = s[5:]
+		} else if strings.HasPrefix(lower, "shift-") && len(s) > 6 {
+			k.Mod |= tcell.ModShift
+			s = s[6:]
+		} else {
+			break
+		}
+	}
+
+	if r, size := utf8.DecodeRuneInString(s); size == len(s) && r != utf8.RuneError {
+		if k.Mod&tcell.ModCtrl == 0 {
+			k.Key = tcell.KeyRune
+			k.Rune = r
+			return normalizeKey(k), nil
+		}
+		r = unicode.ToUpper(r)
+		if key, ok := ctrlKeys[r]; ok {
+			k.Key = key
+			return normalizeKey(k), nil
+		}
+		for key, name := range tcell.KeyNames {
+			if name == "Ctrl-"+string(r) {
+				k.Key = key
+				return normalizeKey(k), nil
+			}
+		}
+		return k, fmt.Errorf("unknown key %q", desc)
+	}
+
+	switch lower := strings.ToLower(s); lower {
+	case "space":
+		if k.Mod&tcell.ModCtrl != 0 {
+			k.Key = tcell.KeyCtrlSpace
+		} else {
+			k.Key = tcell.KeyRune
+			k.Rune = ' '
+		}
+	default:
+		key, ok := keyNames[lower]
+		if !ok {
+			return k, fmt.Errorf("unknown key %q", desc)
+		}
+		k.Key = key
+		if key == tcell.KeyTab && k.Mod&tcell.ModShift != 0 {
+			k.Key = tcell.KeyBacktab
+			k.Mod &^= tcell.ModShift
+		}
+	}
+	return normalizeKey(k), nil
+}
+
+// actions are the functions that keys can be bound to, by name.
+var actions map[string]func(app *App)
+
+func init() {
+	actions = map[string]func(app *App){
+		"input-clear": func(app *App) {
+			if app.win.InputClear() {
+				app.typing()
+			} else {
+				app.win.InputSet("/quit")
+			}
+		},
+		"input-send": func(app *App) {
+			app.submitInput(app.win.InputEnter())
+		},
+		"input-newline": func(app *App) {
+			app.win.InputRune('\n')
+		},
+		"redraw": func(app *App) {
+			app.win.Resize()
+		},
+		"scroll-up": func(app *App) {
+			app.win.ScrollUp()
+			app.requestHistory()
+		},
+		"scroll-down": func(app *App) {
+			app.win.ScrollDown()
+		},
+		"scroll-up-highlight": func(app *App) {
+			app.win.ScrollUpHighlight()
+		},
+		"scroll-down-highlight": func(app *App) {
+			app.win.ScrollDownHighlight()
+		},
+		"buffer-next": func(app *App) {
+			app.win.NextBuffer()
+		},
+		"buffer-previous": func(app *App) {
+			app.win.PreviousBuffer()
+		},
+		"buffer-first": func(app *App) {
+			app.win.GoToBufferNo(0)
+		},
+		"buffer-last": func(app *App) {
+			maxInt := int(^uint(0) >> 1)
+			app.win.GoToBufferNo(maxInt)
+		},
+		"buffer-next-unread": func(app *App) {
+			cur := app.win.CurrentBufferID()
+			if app.win.GoToNextUnread() {
+				if app.bufferBeforeCyclingUnread == -1 {
+					app.bufferBeforeCyclingUnread = cur
+				}
+			} else {
+				app.win.GoToBufferNo(app.bufferBeforeCyclingUnread)
+				app.bufferBeforeCyclingUnread = -1
+			}
+		},
+		"select-up": func(app *App) {
+			app.win.SelectUp()
+		},
+		"select-down": func(app *App) {
+			app.win.SelectDown()
+		},
+		"cursor-left": func(app *App) {
+			app.win.InputLeft()
+		},
+		"cursor-right": func(app *App) {
+			app.win.InputRight()
+		},
+		"cursor-left-word": func(app *App) {
+			app.win.InputLeftWord()
+		},
+		"cursor-right-word": func(app *App) {
+			app.win.InputRightWord()
+		},
+		"cursor-home": func(app *App) {
+			app.win.InputHome()
+		},
+		"cursor-end": func(app *App) {
+			app.win.InputEnd()
+		},
+		"history-previous": func(app *App) {
+			app.win.InputUp()
+		},
+		"history-next": func(app *App) {
+			app.win.InputDown()
+		},
+		"history-search": func(app *App) {
+			app.win.InputBackSearch()
+		},
+		"delete-backward": func(app *App) {
+			if app.win.InputBackspace() {
+				app.typing()
+			}
+		},
+		"delete-forward": func(app *App) {
+			if app.win.InputDelete() {
+				app.typing()
+			}
+		},
+		"delete-word-backward": func(app *App) {
+			if app.win.InputDeleteWord() {
+				app.typing()
+			}
+		},
+		"complete-next": func(app *App) {
+			if app.win.InputAutoComplete(1) {
+				app.typing()
+			}
+		},
+		"complete-previous": func(app *App) {
+			if app.win.InputAutoComplete(-1) {
+				app.typing()
+			}
+		},
+		"cancel": func(app *App) {
+			app.win.CloseOverlay()
+			app.win.ClearSelection()
+			app.pasteQueue = nil // cancel the sending of pasted lines.
+		},
+		"toggle-channel-list": func(app *App) {
+			app.win.ToggleChannelList()
+		},
+		"toggle-member-list": func(app *App) {
+			app.win.ToggleMemberList()
+		},
+	}
+	for i := 1; i <= 9; i++ {
+		i := i
+		actions[fmt.Sprintf("buffer-%d", i)] = func(app *App) {
+			app.win.GoToBufferNo(i - 1)
+		}
+	}
+}
+
+// defaultBindings are the actions bound to keys unless changed in the
+// configuration.
+var defaultBindings = map[string]string{
+	"ctrl-c":        "input-clear",
+	"enter":         "input-send",
+	"alt-enter":     "input-newline",
+	"ctrl-l":        "redraw",
+	"ctrl-u":        "scroll-up",
+	"pageup":        "scroll-up",
+	"ctrl-d":        "scroll-down",
+	"pagedown":      "scroll-down",
+	"alt-p":         "scroll-up-highlight",
+	"alt-n":         "scroll-down-highlight",
+	"ctrl-n":        "buffer-next",
+	"alt-right":     "buffer-next",
+	"alt-down":      "buffer-next",
+	"ctrl-p":        "buffer-previous",
+	"alt-left":      "buffer-previous",
+	"alt-up":        "buffer-previous",
+	"alt-home":      "buffer-first",
+	"alt-end":       "buffer-last",
+	"alt-a":         "buffer-next-unread",
+	"ctrl-up":       "select-up",
+	"ctrl-down":     "select-down",
+	"left":          "cursor-left",
+	"right":         "cursor-right",
+	"ctrl-left":     "cursor-left-word",
+	"ctrl-right":    "cursor-right-word",
+	"home":          "cursor-home",
+	"end":           "cursor-end",
+	"up":            "history-previous",
+	"down":          "history-next",
+	"ctrl-r":        "history-search",
+	"backspace":     "delete-backward",
+	"delete":        "delete-forward",
+	"alt-backspace": "delete-word-backward",
+	"ctrl-w":        "delete-word-backward",
+	"tab":           "complete-next",
+	"shift-tab":     "complete-previous",
+	"escape":        "cancel",
+	"f7":            "toggle-channel-list",
+	"f8":            "toggle-member-list",
+	"alt-1":         "buffer-1",
+	"alt-2":         "buffer-2",
+	"alt-3":         "buffer-3",
+	"alt-4":         "buffer-4",
+	"alt-5":         "buffer-5",
+	"alt-6":         "buffer-6",
+	"alt-7":         "buffer-7",
+	"alt-8":         "buffer-8",
+	"alt-9":         "buffer-9",
+}
+
+// checkBinding returns an error if binding is neither an action nor a
+// command.
+func checkBinding(binding string) error {
+	if _, ok := actions[binding]; ok || binding == "none" || strings.HasPrefix(binding, "/") {
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", binding)
+}
+
+// initBindings sets the key bindings of app from the defaults and from the
+// configuration.
+func (app *App) initBindings() {
+	app.bindings = make(map[Key]string, len(defaultBindings))
+	for desc, binding := range defaultBindings {
+		k, err := ParseKey(desc)
+		if err != nil {
+			panic(err)
+		}
+		app.bindings[k] = binding
+	}
+	for k, binding := range app.cfg.Bindings {
+		if binding == "none" {
+			delete(app.bindings, k)
+		} else {
+			app.bindings[k] = binding
+		}
+	}
+}
+
+// binding returns the action or command bound to k or, if there is none and
+// SHIFT is pressed, to k without SHIFT, so that SHIFT-LEFT moves the cursor
+// like LEFT unless it is bound.
+func (app *App) binding(k Key) (string, bool) {
+	binding, ok := app.bindings[k]
+	if !ok && k.Mod&tcell.ModShift != 0 {
+		k.Mod &^= tcell.ModShift
+		binding, ok = app.bindings[k]
+	}
+	return binding, ok
+}
+
+// handleBinding runs the action or command bound to the key pressed in ev, and
+// reports whether there is one.
+func (app *App) handleBinding(ev *tcell.EventKey) bool {
+	binding, ok := app.binding(keyOf(ev))
+	if !ok {
+		return false
+	}
+	if strings.HasPrefix(binding, "/") {
+		app.submitInput(binding)
+	} else {
+		actions[binding](app)
+	}
+	return true
+}
+
+// submitInput handles input as if it was sent from the input field, and shows
+// errors in the current buffer.
+func (app *App) submitInput(input string) {
+	netID, buffer := app.win.CurrentBuffer()
+	err := app.handleInput(buffer, input)
+	if err != nil {
+		app.win.AddLine(netID, buffer, ui.NotifyUnread, ui.Line{
+			At:        time.Now(),
+			Head:      "!!",
+			HeadColor: app.cfg.Theme.Error,
+			Body:      ui.PlainSprintf("%q: %s", input, err),
+		})
+	}
+}
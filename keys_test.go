@@ -0,0 +1,78 @@
+package senpai
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseKey(t *testing.T) {
+	for desc, ev := range map[string]*tcell.EventKey{
+		"ctrl-n":        tcell.NewEventKey(tcell.KeyCtrlN, 0, tcell.ModCtrl),
+		"Ctrl-N":        tcell.NewEventKey(tcell.KeyCtrlN, 0, tcell.ModCtrl),
+		"alt-1":         tcell.NewEventKey(tcell.KeyRune, '1', tcell.ModAlt),
+		"alt-A":         tcell.NewEventKey(tcell.KeyRune, 'A', tcell.ModAlt|tcell.ModShift),
+		"alt--":         tcell.NewEventKey(tcell.KeyRune, '-', tcell.ModAlt),
+		"f7":            tcell.NewEventKey(tcell.KeyF7, 0, tcell.ModNone),
+		"alt-right":     tcell.NewEventKey(tcell.KeyRight, 0, tcell.ModAlt),
+		"shift-tab":     tcell.NewEventKey(tcell.KeyBacktab, 0, tcell.ModNone),
+		"enter":         tcell.NewEventKey(tcell.KeyLF, 0, tcell.ModNone),
+		"alt-enter":     tcell.NewEventKey(tcell.KeyCR, 0, tcell.ModAlt),
+		"backspace":     tcell.NewEventKey(tcell.KeyBackspace, 0, tcell.ModNone),
+		"alt-backspace": tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModAlt),
+		"escape":        tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone),
+		"ctrl-space":    tcell.NewEventKey(tcell.KeyCtrlSpace, 0, tcell.ModCtrl),
+		"ctrl-h":        tcell.NewEventKey(tcell.KeyCtrlH, 0, tcell.ModCtrl),
+		"ctrl-i":        tcell.NewEventKey(tcell.KeyCtrlI, 0, tcell.ModCtrl),
+		"ctrl-m":        tcell.NewEventKey(tcell.KeyCtrlM, 0, tcell.ModCtrl),
+		"ctrl-[":        tcell.NewEventKey(tcell.KeyCtrlLeftSq, 0, tcell.ModCtrl),
+	} {
+		k, err := ParseKey(desc)
+		if err != nil {
+			t.Errorf("%q: %v", desc, err)
+			continue
+		}
+		if actual := keyOf(ev); actual != k {
+			t.Errorf("%q: expected %+v, got %+v for %s", desc, k, actual, ev.Name())
+		}
+	}
+
+	for _, desc := range []string{"", "ctrl-", "hyper-a", "ctrl-é", "f99"} {
+		if _, err := ParseKey(desc); err == nil {
+			t.Errorf("%q: expected an error", desc)
+		}
+	}
+
+	for desc := range defaultBindings {
+		if _, err := ParseKey(desc); err != nil {
+			t.Errorf("default binding %q: %v", desc, err)
+		}
+	}
+}
+
+func TestBindingShift(t *testing.T) {
+	app := &App{}
+	app.initBindings()
+	shiftLeft, _ := ParseKey("shift-left")
+
+	for desc, expected := range map[string]string{
+		"shift-left":      "cursor-left",
+		"shift-home":      "cursor-home",
+		"shift-backspace": "delete-backward",
+		"shift-delete":    "delete-forward",
+		"shift-enter":     "input-send",
+	} {
+		k, err := ParseKey(desc)
+		if err != nil {
+			t.Fatalf("%q: %v", desc, err)
+		}
+		if binding, ok := app.binding(k); !ok || binding != expected {
+			t.Errorf("%q: expected %q, got %q", desc, expected, binding)
+		}
+	}
+
+	app.bindings[shiftLeft] = "cursor-home"
+	if binding, _ := app.binding(shiftLeft); binding != "cursor-home" {
+		t.Errorf("shift-left: expected its own binding, got %q", binding)
+	}
+}
@@ -0,0 +1,569 @@
+package senpai
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"git.sr.ht/~taiite/senpai/irc"
+	"git.sr.ht/~taiite/senpai/ui"
+)
+
+// Formats of the local message log.
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+const (
+	logTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+	logDayFormat  = "2006-01-02"
+)
+
+// logEntry is a message or a membership event of the local message log.
+type logEntry struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // message, join, part, quit, nick, topic or mode.
+	Command string    `json:"command,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Prefix  string    `json:"prefix,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Former  string    `json:"former,omitempty"` // former nickname of nick changes.
+	Content string    `json:"content,omitempty"`
+	ID      string    `json:"id,omitempty"`
+	ReplyTo string    `json:"reply_to,omitempty"`
+}
+
+// marshalText returns the lines of e in the text format.
+func (e logEntry) marshalText() []string {
+	t := e.Time.Format(logTimeFormat)
+	switch e.Type {
+	case "message":
+		content := strings.TrimSuffix(e.Content, "\x01")
+		var head string
+		if strings.HasPrefix(content, "\x01ACTION") {
+			content = strings.TrimPrefix(content[7:], " ")
+			head = "* " + e.User
+		} else if e.Command == "NOTICE" {
+			head = "-" + e.User + "-"
+		} else {
+			head = "<" + e.User + ">"
+		}
+		content = ui.IRCString(content).String()
+		var lines []string
+		for _, l := range strings.Split(content, "\n") {
+			lines = append(lines, fmt.Sprintf("%s %s %s", t, head, l))
+		}
+		return lines
+	case "join":
+		return []string{fmt.Sprintf("%s --> %s joined", t, e.User)}
+	case "part":
+		return []string{fmt.Sprintf("%s <-- %s left", t, e.User)}
+	case "quit":
+		return []string{fmt.Sprintf("%s <-- %s quit", t, e.User)}
+	case "nick":
+		return []string{fmt.Sprintf("%s -- %s is now known as %s", t, e.Former, e.User)}
+	case "topic":
+		return []string{fmt.Sprintf("%s -- topic: %s", t, ui.IRCString(e.Content).String())}
+	case "mode":
+		return []string{fmt.Sprintf("%s -- mode: %s", t, e.Content)}
+	}
+	return nil
+}
+
+// parseTextEntry parses a line written by logEntry.marshalText.
+func parseTextEntry(line string) (e logEntry, ok bool) {
+	sp := strings.IndexByte(line, ' ')
+	if sp < 0 {
+		return e, false
+	}
+	t, err := time.Parse(logTimeFormat, line[:sp])
+	if err != nil {
+		return e, false
+	}
+	e.Time = t
+	line = line[sp+1:]
+
+	switch {
+	case strings.HasPrefix(line, "--> "):
+		e.Type = "join"
+		e.User = strings.TrimSuffix(line[4:], " joined")
+	case strings.HasPrefix(line, "<-- "):
+		line = line[4:]
+		if strings.HasSuffix(line, " quit") {
+			e.Type = "quit"
+			e.User = strings.TrimSuffix(line, " quit")
+		} else {
+			e.Type = "part"
+			e.User = strings.TrimSuffix(line, " left")
+		}
+	case strings.HasPrefix(line, "-- topic: "):
+		e.Type = "topic"
+		e.Content = line[10:]
+	case strings.HasPrefix(line, "-- mode: "):
+		e.Type = "mode"
+		e.Content = line[9:]
+	case strings.HasPrefix(line, "-- "):
+		fields := strings.Fields(line[3:])
+		if len(fields) != 6 {
+			return e, false
+		}
+		e.Type = "nick"
+		e.Former = fields[0]
+		e.User = fields[5]
+	case strings.HasPrefix(line, "<"):
+		end := strings.Index(line, "> ")
+		if end < 0 {
+			return e, false
+		}
+		e.Type = "message"
+		e.Command = "PRIVMSG"
+		e.User = line[1:end]
+		e.Content = line[end+2:]
+	case strings.HasPrefix(line, "-"):
+		end := strings.Index(line, "- ")
+		if end < 0 {
+			return e, false
+		}
+		e.Type = "message"
+		e.Command = "NOTICE"
+		e.User = line[1:end]
+		e.Content = line[end+2:]
+	case strings.HasPrefix(line, "* "):
+		fields := strings.SplitN(line[2:], " ", 2)
+		if len(fields) != 2 {
+			return e, false
+		}
+		e.Type = "message"
+		e.Command = "PRIVMSG"
+		e.User = fields[0]
+		e.Content = "\x01ACTION " + fields[1] + "\x01"
+	default:
+		return e, false
+	}
+	return e, e.User != "" || e.Type == "topic" || e.Type == "mode"
+}
+
+// event returns e as an event of target, as if it came from the history of
+// the server.
+func (e logEntry) event(s *irc.Session, target string) irc.Event {
+	prefix := irc.ParsePrefix(e.Prefix)
+	if prefix == nil && e.User != "" {
+		prefix = &irc.Prefix{Name: e.User}
+	}
+	switch e.Type {
+	case "message":
+		if e.Target != "" {
+			target = e.Target
+		}
+		return irc.MessageEvent{
+			User:            e.User,
+			Prefix:          prefix,
+			Target:          target,
+			TargetIsChannel: s.IsChannel(target),
+			Command:         e.Command,
+			Content:         e.Content,
+			Time:            e.Time,
+			ID:              e.ID,
+			ReplyTo:         e.ReplyTo,
+		}
+	case "join":
+		return irc.UserJoinEvent{User: e.User, Prefix: prefix, Channel: target, Time: e.Time}
+	case "part":
+		return irc.UserPartEvent{User: e.User, Prefix: prefix, Channel: target, Time: e.Time}
+	case "quit":
+		return irc.UserQuitEvent{User: e.User, Prefix: prefix, Time: e.Time}
+	case "nick":
+		return irc.UserNickEvent{User: e.User, FormerNick: e.Former, Time: e.Time}
+	case "topic":
+		return irc.TopicChangeEvent{Channel: target, Topic: e.Content, Time: e.Time}
+	case "mode":
+		return irc.ModeChangeEvent{Channel: target, Mode: e.Content, Time: e.Time}
+	}
+	return nil
+}
+
+// logger writes events to the local message log, in one directory per
+// network and target, and one file per day.
+type logger struct {
+	dir    string
+	format string
+	keep   int                 // number of days of logs to keep, or 0 to keep them all.
+	files  map[string]*logFile // open files, by directory.
+}
+
+type logFile struct {
+	day string
+	f   *os.File
+}
+
+func newLogger(dir, format string, keep int) *logger {
+	return &logger{
+		dir:    dir,
+		format: format,
+		keep:   keep,
+		files:  map[string]*logFile{},
+	}
+}
+
+// logPathEscaper escapes the names of networks and targets, so that they can
+// be used as file names.
+var logPathEscaper = strings.NewReplacer("%", "%25", "/", "%2F", "\x00", "%00")
+
+func logPathEscape(name string) string {
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return logPathEscaper.Replace(name)
+}
+
+func (l *logger) ext() string {
+	if l.format == LogJSON {
+		return ".jsonl"
+	}
+	return ".log"
+}
+
+// write appends e to the log of target on network, a path already escaped
+// with logPathEscape.
+func (l *logger) write(network, target string, e logEntry) error {
+	dir := filepath.Join(l.dir, network, logPathEscape(target))
+	day := e.Time.Local().Format(logDayFormat)
+	lf, ok := l.files[dir]
+	if !ok || lf.day != day {
+		if ok {
+			lf.f.Close()
+			delete(l.files, dir)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(dir, day+l.ext()), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		lf = &logFile{day: day, f: f}
+		l.files[dir] = lf
+		l.rotate(dir, e.Time)
+	}
+
+	var buf []byte
+	if l.format == LogJSON {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(b, '\n')
+	} else {
+		for _, line := range e.marshalText() {
+			buf = append(buf, line...)
+			buf = append(buf, '\n')
+		}
+	}
+	_, err := lf.f.Write(buf)
+	return err
+}
+
+// rotate removes the files of dir older than l.keep days before now.
+func (l *logger) rotate(dir string, now time.Time) {
+	if l.keep == 0 {
+		return
+	}
+	oldest := now.Local().AddDate(0, 0, -l.keep).Format(logDayFormat)
+	for _, day := range l.days(dir) {
+		if oldest <= day {
+			break
+		}
+		os.Remove(filepath.Join(dir, day+l.ext()))
+	}
+}
+
+// days returns the days of the files of dir, in order.
+func (l *logger) days(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var days []string
+	for _, entry := range entries {
+		if day := strings.TrimSuffix(entry.Name(), l.ext()); day != entry.Name() {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days
+}
+
+// readDay returns the entries of the file of day in dir.
+func (l *logger) readDay(dir, day string) ([]logEntry, error) {
+	f, err := os.Open(filepath.Join(dir, day+l.ext()))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []logEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e logEntry
+		if l.format == LogJSON {
+			if json.Unmarshal(sc.Bytes(), &e) != nil {
+				continue
+			}
+		} else {
+			var ok bool
+			if e, ok = parseTextEntry(sc.Text()); !ok {
+				continue
+			}
+			if n := len(entries); n != 0 && e.Type == "message" && entries[n-1].Type == "message" &&
+				entries[n-1].Time.Equal(e.Time) && entries[n-1].User == e.User && entries[n-1].Command == e.Command {
+				// Continuation of a multiline message.
+				entries[n-1].Content += "\n" + e.Content
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// readBefore returns the last limit entries of target on network sent before
+// t, in order.
+func (l *logger) readBefore(network, target string, t time.Time, limit int) ([]logEntry, error) {
+	dir := filepath.Join(l.dir, network, logPathEscape(target))
+	last := t.Local().Format(logDayFormat)
+	days := l.days(dir)
+	var entries []logEntry
+	for i := len(days) - 1; 0 <= i && len(entries) < limit; i-- {
+		if last < days[i] {
+			continue
+		}
+		dayEntries, err := l.readDay(dir, days[i])
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for _, e := range dayEntries {
+			if e.Time.Before(t) {
+				dayEntries[n] = e
+				n++
+			}
+		}
+		entries = append(dayEntries[:n], entries...)
+	}
+	if limit < len(entries) {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+// readAfter returns the first limit entries of target on network sent after
+// t, in order.
+func (l *logger) readAfter(network, target string, t time.Time, limit int) ([]logEntry, error) {
+	dir := filepath.Join(l.dir, network, logPathEscape(target))
+	first := t.Local().Format(logDayFormat)
+	var entries []logEntry
+	for _, day := range l.days(dir) {
+		if len(entries) >= limit {
+			break
+		}
+		if day < first {
+			continue
+		}
+		dayEntries, err := l.readDay(dir, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range dayEntries {
+			if e.Time.After(t) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	if limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// close closes the open files.
+func (l *logger) close() {
+	for dir, lf := range l.files {
+		lf.f.Close()
+		delete(l.files, dir)
+	}
+}
+
+// logNetwork returns the directory of the logs of netID, relative to the log
+// directory.
+func (app *App) logNetwork(netID string) string {
+	network := app.networks[netID]
+	name := network.Name
+	if name == "" {
+		name, _, _ = net.SplitHostPort(addrWithPort(network))
+	}
+	dir := logPathEscape(name)
+	if bn, ok := app.bouncerNetworks[netID]; ok {
+		dir = filepath.Join(dir, logPathEscape(bn.name))
+	}
+	return dir
+}
+
+// logEvent writes the messages and membership events of ev to the local
+// message log, if enabled.
+func (app *App) logEvent(netID string, s *irc.Session, ev irc.Event) {
+	if app.log == nil {
+		return
+	}
+	type logged struct {
+		target string
+		entry  logEntry
+	}
+	var entries []logged
+	switch ev := ev.(type) {
+	case irc.MessageEvent:
+		target := ev.Target
+		if !ev.TargetIsChannel && s.IsMe(ev.Target) {
+			target = ev.User
+		}
+		entries = append(entries, logged{target, logEntry{
+			Time:    ev.Time,
+			Type:    "message",
+			Command: ev.Command,
+			User:    ev.User,
+			Prefix:  ev.Prefix.String(),
+			Target:  ev.Target,
+			Content: ev.Content,
+			ID:      ev.ID,
+			ReplyTo: ev.ReplyTo,
+		}})
+	case irc.UserJoinEvent:
+		entries = append(entries, logged{ev.Channel, logEntry{
+			Time:   ev.Time,
+			Type:   "join",
+			User:   ev.User,
+			Prefix: ev.Prefix.String(),
+		}})
+	case irc.UserPartEvent:
+		entries = append(entries, logged{ev.Channel, logEntry{
+			Time:   ev.Time,
+			Type:   "part",
+			User:   ev.User,
+			Prefix: ev.Prefix.String(),
+		}})
+	case irc.UserQuitEvent:
+		for _, c := range ev.Channels {
+			entries = append(entries, logged{c, logEntry{
+				Time:   ev.Time,
+				Type:   "quit",
+				User:   ev.User,
+				Prefix: ev.Prefix.String(),
+			}})
+		}
+	case irc.UserNickEvent:
+		for _, c := range s.ChannelsSharedWith(ev.User) {
+			entries = append(entries, logged{c, logEntry{
+				Time:   ev.Time,
+				Type:   "nick",
+				User:   ev.User,
+				Former: ev.FormerNick,
+			}})
+		}
+	case irc.TopicChangeEvent:
+		entries = append(entries, logged{ev.Channel, logEntry{
+			Time:    ev.Time,
+			Type:    "topic",
+			Content: ev.Topic,
+		}})
+	case irc.ModeChangeEvent:
+		entries = append(entries, logged{ev.Channel, logEntry{
+			Time:    ev.Time,
+			Type:    "mode",
+			Content: ev.Mode,
+		}})
+	case irc.LabeledEvent:
+		// Replies to our commands, such as the echo of our messages.
+		for _, reply := range ev.Events {
+			app.logEvent(netID, s, reply)
+		}
+		return
+	}
+
+	network := app.logNetwork(netID)
+	for _, e := range entries {
+		if e.target == "" {
+			continue
+		}
+		if err := app.log.write(network, s.Casemap(e.target), e.entry); err != nil {
+			app.log.close()
+			app.log = nil
+			app.addStatusLine(netID, ui.Line{
+				At:        time.Now(),
+				Head:      "!!",
+				HeadColor: app.cfg.Theme.Error,
+				Body:      ui.PlainSprintf("Failed to write the message log, logging is disabled: %v", err),
+			})
+			return
+		}
+	}
+}
+
+// historyBefore requests the last limit messages of target sent before t, from
+// the server or, if it does not keep history, from the local message log.
+func (app *App) historyBefore(netID string, s *irc.Session, target string, limit int, t time.Time) {
+	if app.log == nil || s.HasCapability("draft/chathistory") {
+		s.NewHistoryRequest(target).
+			WithLimit(limit).
+			Before(t)
+		return
+	}
+	entries, err := app.log.readBefore(app.logNetwork(netID), s.Casemap(target), t, limit)
+	app.backfill(netID, s, target, entries, err)
+}
+
+// historyAfter requests the first limit messages of target sent after t, from
+// the server or, if it does not keep history, from the local message log.
+func (app *App) historyAfter(netID string, s *irc.Session, target string, limit int, t time.Time) {
+	if app.log == nil || s.HasCapability("draft/chathistory") {
+		s.NewHistoryRequest(target).
+			WithLimit(limit).
+			After(t)
+		return
+	}
+	entries, err := app.log.readAfter(app.logNetwork(netID), s.Casemap(target), t, limit)
+	app.backfill(netID, s, target, entries, err)
+}
+
+// backfill adds entries read from the local message log to the timeline of
+// target, as history from the server would be.
+func (app *App) backfill(netID string, s *irc.Session, target string, entries []logEntry, err error) {
+	if err != nil {
+		app.win.AddLine(netID, target, ui.NotifyNone, ui.Line{
+			At:        time.Now(),
+			Head:      "!!",
+			HeadColor: app.cfg.Theme.Error,
+			Body:      ui.PlainSprintf("Failed to read the message log: %v", err),
+		})
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	ev := irc.HistoryEvent{
+		Target:   target,
+		Messages: make([]irc.Event, 0, len(entries)),
+	}
+	for _, e := range entries {
+		if m := e.event(s, target); m != nil {
+			ev.Messages = append(ev.Messages, m)
+		}
+	}
+	app.handleSessionEvent(netID, s, irc.Message{}, app.filterIgnored(netID, s, "", ev))
+}
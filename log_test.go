@@ -0,0 +1,122 @@
+package senpai
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"git.sr.ht/~taiite/senpai/irc"
+)
+
+func TestLogText(t *testing.T) {
+	at := time.Date(2022, 3, 4, 12, 30, 0, 0, time.UTC)
+	for _, e := range []logEntry{
+		{Time: at, Type: "message", Command: "PRIVMSG", User: "dan", Content: "hello <world>"},
+		{Time: at, Type: "message", Command: "PRIVMSG", User: "dan", Content: "\x01ACTION waves\x01"},
+		{Time: at, Type: "message", Command: "NOTICE", User: "my-bot", Content: "- hi -"},
+		{Time: at, Type: "join", User: "dan"},
+		{Time: at, Type: "part", User: "dan"},
+		{Time: at, Type: "quit", User: "dan"},
+		{Time: at, Type: "nick", User: "dan_", Former: "dan"},
+		{Time: at, Type: "topic", Content: "senpai -- the client"},
+		{Time: at, Type: "mode", Content: "+o dan"},
+	} {
+		lines := e.marshalText()
+		if len(lines) != 1 {
+			t.Errorf("%+v: expected 1 line, got %q", e, lines)
+			continue
+		}
+		parsed, ok := parseTextEntry(lines[0])
+		if !ok {
+			t.Errorf("%q: failed to parse", lines[0])
+			continue
+		}
+		if !parsed.Time.Equal(e.Time) {
+			t.Errorf("%q: expected time %v, got %v", lines[0], e.Time, parsed.Time)
+		}
+		parsed.Time = e.Time
+		if !reflect.DeepEqual(parsed, e) {
+			t.Errorf("%q: expected %+v, got %+v", lines[0], e, parsed)
+		}
+	}
+}
+
+func TestLogLabeledReply(t *testing.T) {
+	s := irc.NewSession(make(chan irc.Message, 64), irc.SessionParams{
+		Nickname: "nick",
+		Username: "user",
+		RealName: "real",
+	})
+	app := &App{
+		networks: map[string]ConfigNetwork{
+			"": {Name: "libera"},
+		},
+		log: newLogger(t.TempDir(), LogText, 0),
+	}
+	defer app.log.close()
+
+	at := time.Now().Truncate(time.Second)
+	app.logEvent("", s, irc.LabeledEvent{
+		Label:  "1",
+		Origin: "#senpai",
+		Events: []irc.Event{
+			irc.MessageEvent{
+				User:            "nick",
+				Target:          "#senpai",
+				TargetIsChannel: true,
+				Command:         "PRIVMSG",
+				Content:         "hello",
+				Time:            at,
+			},
+		},
+	})
+
+	entries, err := app.log.readBefore("libera", "#senpai", at.Add(time.Second), 10)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if len(entries) != 1 || entries[0].User != "nick" || entries[0].Content != "hello" {
+		t.Errorf("expected the echo of our message to be logged, got %+v", entries)
+	}
+}
+
+func TestLogRead(t *testing.T) {
+	start := time.Now().Add(-72 * time.Hour)
+	for _, format := range []string{LogText, LogJSON} {
+		l := newLogger(t.TempDir(), format, 0)
+		var written []logEntry
+		for i := 0; i < 6; i++ {
+			e := logEntry{
+				Time:    start.Add(time.Duration(i) * 12 * time.Hour).Truncate(time.Millisecond),
+				Type:    "message",
+				Command: "PRIVMSG",
+				User:    "dan",
+				Content: string(rune('a' + i)),
+			}
+			if i == 5 {
+				e.Content = "first line\nsecond line"
+			}
+			if err := l.write("libera", "#senpai", e); err != nil {
+				t.Fatalf("%s: failed to write: %v", format, err)
+			}
+			written = append(written, e)
+		}
+		l.close()
+
+		entries, err := l.readBefore("libera", "#senpai", written[4].Time, 3)
+		if err != nil {
+			t.Fatalf("%s: failed to read: %v", format, err)
+		}
+		if len(entries) != 3 || entries[0].Content != "b" || entries[2].Content != "d" {
+			t.Errorf("%s: expected entries b to d before e, got %+v", format, entries)
+		}
+
+		entries, err = l.readAfter("libera", "#senpai", written[2].Time, 10)
+		if err != nil {
+			t.Fatalf("%s: failed to read: %v", format, err)
+		}
+		if len(entries) != 3 || entries[0].Content != "d" || entries[2].Content != written[5].Content {
+			t.Errorf("%s: expected entries d to f after c, got %+v", format, entries)
+		}
+	}
+}
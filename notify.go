@@ -0,0 +1,119 @@
+package senpai
+
+import (
+	"fmt"
+	"strings"
+
+	"git.sr.ht/~taiite/senpai/ui"
+)
+
+// ParseNotifyLevel returns the level of the given name, one of "all",
+// "highlight" and "none".
+func ParseNotifyLevel(name string) (ui.NotifyLevel, error) {
+	for _, level := range []ui.NotifyLevel{ui.NotifyLevelAll, ui.NotifyLevelHighlight, ui.NotifyLevelNone} {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown notification level %q, expected all, highlight or none", name)
+}
+
+// NotifyRule sets the notification level of some buffers.
+type NotifyRule struct {
+	Level   ui.NotifyLevel
+	Network string   // name of the network, empty for all networks.
+	Buffers []string // empty for all buffers.
+}
+
+// Match returns whether the rule applies to buffer, on a network with one of
+// the given names.
+func (r *NotifyRule) Match(networks []string, buffer string) bool {
+	if r.Network != "" {
+		found := false
+		for _, network := range networks {
+			if network == r.Network {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Buffers) == 0 {
+		return true
+	}
+	for _, b := range r.Buffers {
+		if strings.EqualFold(b, buffer) {
+			return true
+		}
+	}
+	return false
+}
+
+// configNotifyLevel returns the level of buffer on netID set by the last
+// matching rule of the configuration.
+func (app *App) configNotifyLevel(netID, buffer string) ui.NotifyLevel {
+	level := ui.NotifyLevelAll
+	if len(app.cfg.Notify) == 0 {
+		return level
+	}
+	networks := app.networkNames(netID)
+	for _, rule := range app.cfg.Notify {
+		if rule.Match(networks, buffer) {
+			level = rule.Level
+		}
+	}
+	return level
+}
+
+// notifyLevel returns the level of buffer on netID, set with /NOTIFY or else
+// by the configuration.
+func (app *App) notifyLevel(netID, buffer string) ui.NotifyLevel {
+	if level, ok := app.notifyLevels[netID][strings.ToLower(buffer)]; ok {
+		return level
+	}
+	return app.configNotifyLevel(netID, buffer)
+}
+
+// setNotifyLevel sets the level of buffer on netID, overriding the
+// configuration unless it is the same.
+func (app *App) setNotifyLevel(netID, buffer string, level ui.NotifyLevel) {
+	buffer = strings.ToLower(buffer)
+	if level == app.configNotifyLevel(netID, buffer) {
+		delete(app.notifyLevels[netID], buffer)
+		if len(app.notifyLevels[netID]) == 0 {
+			delete(app.notifyLevels, netID)
+		}
+		return
+	}
+	if app.notifyLevels[netID] == nil {
+		app.notifyLevels[netID] = map[string]ui.NotifyLevel{}
+	}
+	app.notifyLevels[netID][buffer] = level
+}
+
+// SetNotifyLevels sets the levels of buffers set with /NOTIFY, by netID and
+// lowercase buffer name.
+func (app *App) SetNotifyLevels(levels map[string]map[string]ui.NotifyLevel) {
+	for netID, buffers := range levels {
+		if app.notifyLevels[netID] == nil {
+			app.notifyLevels[netID] = map[string]ui.NotifyLevel{}
+		}
+		for buffer, level := range buffers {
+			app.notifyLevels[netID][strings.ToLower(buffer)] = level
+		}
+	}
+}
+
+// NotifyLevels returns the levels of buffers set with /NOTIFY, by netID and
+// lowercase buffer name.
+func (app *App) NotifyLevels() map[string]map[string]ui.NotifyLevel {
+	levels := make(map[string]map[string]ui.NotifyLevel, len(app.notifyLevels))
+	for netID, buffers := range app.notifyLevels {
+		levels[netID] = make(map[string]ui.NotifyLevel, len(buffers))
+		for buffer, level := range buffers {
+			levels[netID][buffer] = level
+		}
+	}
+	return levels
+}
@@ -0,0 +1,43 @@
+package senpai
+
+import (
+	"testing"
+
+	"git.sr.ht/~taiite/senpai/ui"
+)
+
+func TestParseNotifyLevel(t *testing.T) {
+	for _, level := range []ui.NotifyLevel{ui.NotifyLevelAll, ui.NotifyLevelHighlight, ui.NotifyLevelNone} {
+		parsed, err := ParseNotifyLevel(level.String())
+		if err != nil || parsed != level {
+			t.Errorf("%q: expected %d, got %d (%v)", level.String(), level, parsed, err)
+		}
+	}
+	if _, err := ParseNotifyLevel("some"); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
+
+func TestNotifyRuleMatch(t *testing.T) {
+	rule := NotifyRule{
+		Level:   ui.NotifyLevelNone,
+		Network: "libera",
+		Buffers: []string{"#Senpai"},
+	}
+	networks := []string{"libera"}
+
+	if !rule.Match(networks, "#senpai") {
+		t.Errorf("expected #senpai to match")
+	}
+	if rule.Match(networks, "#other") {
+		t.Errorf("expected #other not to match")
+	}
+	if rule.Match([]string{"oftc"}, "#senpai") {
+		t.Errorf("expected #senpai on another network not to match")
+	}
+
+	rule = NotifyRule{Level: ui.NotifyLevelHighlight}
+	if !rule.Match(networks, "#other") {
+		t.Errorf("expected a rule without buffers to match all buffers")
+	}
+}
@@ -0,0 +1,186 @@
+package senpai
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"git.sr.ht/~taiite/senpai/ui"
+	"github.com/gdamore/tcell/v2"
+)
+
+// pasteLineDelay is the time between two lines of a paste sent one by one.
+const pasteLineDelay = 500 * time.Millisecond
+
+// pasteConfirmation is a paste in the input field of a buffer, waiting for the
+// user to choose how to send it.
+type pasteConfirmation struct {
+	netID  string
+	buffer string
+}
+
+// pastedLine is a line of a paste waiting to be sent.
+type pastedLine struct {
+	netID   string
+	buffer  string
+	content string
+}
+
+// pasteTick is sent by app.pasteTimer when the next pasted line can be sent.
+type pasteTick struct{}
+
+// pasteLines returns the non-empty lines of content.
+func pasteLines(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// endPaste is called when a paste in the input field has ended, and asks the
+// user how to send it if it has too many lines.  Commands spanning several
+// lines cannot be sent, and are refused right away.
+func (app *App) endPaste() {
+	input := app.win.InputContent()
+	netID, buffer := app.win.CurrentBuffer()
+	if isCommand(input) {
+		if strings.ContainsAny(string(input), "\r\n") {
+			app.win.AddLine(netID, buffer, ui.NotifyNone, ui.Line{
+				At:        time.Now(),
+				Head:      "!!",
+				HeadColor: app.cfg.Theme.Error,
+				Body:      ui.PlainString("Commands cannot span several lines, edit the paste before sending it"),
+			})
+		}
+		return
+	}
+	if app.cfg.PasteLines == 0 || buffer == "" {
+		return
+	}
+	lines := pasteLines(string(input))
+	if len(lines) <= app.cfg.PasteLines {
+		return
+	}
+
+	app.pasteConfirm = &pasteConfirmation{
+		netID:  netID,
+		buffer: buffer,
+	}
+	app.win.OpenOverlay()
+	gray := app.cfg.Theme.StatusStyle()
+	now := time.Now()
+	overlay := []ui.Line{{
+		At:        now,
+		Head:      "--",
+		HeadColor: app.cfg.Theme.Status,
+		Body:      ui.Styled(fmt.Sprintf("Send these %d lines to %s? ENTER: send as is, J: join into one line, ESCAPE: cancel", len(lines), buffer), gray),
+	}}
+	for _, line := range lines {
+		overlay = append(overlay, ui.Line{
+			At:   now,
+			Body: ui.PlainString(line),
+		})
+	}
+	app.win.AddLines("", ui.Overlay, overlay, nil)
+}
+
+// handlePasteConfirmKey handles a key press while the paste confirmation is
+// shown.
+func (app *App) handlePasteConfirmKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyCR, tcell.KeyLF:
+		app.sendPaste(false)
+	case tcell.KeyEscape:
+		// Leave the paste in the input field, so that it can be edited.
+		app.pasteConfirm = nil
+		app.win.CloseOverlay()
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'j', 'J':
+			app.sendPaste(true)
+		case 'c', 'C':
+			app.pasteConfirm = nil
+			app.win.CloseOverlay()
+		}
+	}
+}
+
+// sendPaste sends the confirmed paste, joined into one line or as is.
+func (app *App) sendPaste(join bool) {
+	netID, buffer := app.pasteConfirm.netID, app.pasteConfirm.buffer
+	app.pasteConfirm = nil
+	app.win.CloseOverlay()
+
+	lines := pasteLines(app.win.InputEnter())
+	if join {
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+		app.sendPastedLine(pastedLine{
+			netID:   netID,
+			buffer:  buffer,
+			content: strings.Join(lines, " "),
+		})
+		return
+	}
+	if s := app.sessions[netID]; s != nil && s.HasCapability("draft/multiline") {
+		// Sent at once, in multiline batches.
+		app.sendPastedLine(pastedLine{
+			netID:   netID,
+			buffer:  buffer,
+			content: strings.Join(lines, "\n"),
+		})
+		return
+	}
+	for _, line := range lines {
+		app.pasteQueue = append(app.pasteQueue, pastedLine{
+			netID:   netID,
+			buffer:  buffer,
+			content: line,
+		})
+	}
+	if app.pasteTimer == nil {
+		app.sendPasteQueue()
+	}
+}
+
+// sendPasteQueue sends the next line of app.pasteQueue, and schedules the
+// sending of the following one.  It must not be called while app.pasteTimer
+// is running.
+func (app *App) sendPasteQueue() {
+	if len(app.pasteQueue) == 0 {
+		return
+	}
+	line := app.pasteQueue[0]
+	app.pasteQueue = app.pasteQueue[1:]
+	if !app.sendPastedLine(line) {
+		app.pasteQueue = nil
+	}
+	if len(app.pasteQueue) != 0 {
+		app.pasteTimer = time.AfterFunc(pasteLineDelay, func() {
+			app.events <- event{
+				src:     "*",
+				content: pasteTick{},
+			}
+		})
+	}
+}
+
+// sendPastedLine sends line and reports whether it succeeded, showing the
+// error in its buffer otherwise.
+func (app *App) sendPastedLine(line pastedLine) bool {
+	err := sendMessage(app, line.netID, line.buffer, line.content)
+	if err != nil {
+		app.win.AddLine(line.netID, line.buffer, ui.NotifyUnread, ui.Line{
+			At:        time.Now(),
+			Head:      "!!",
+			HeadColor: app.cfg.Theme.Error,
+			Body:      ui.PlainSprintf("%q: %s", line.content, err),
+		})
+		return false
+	}
+	return true
+}
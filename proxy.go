@@ -0,0 +1,123 @@
+package senpai
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+func init() {
+	proxy.RegisterDialerType("http", newHTTPProxy)
+}
+
+// parseProxy checks the value of the proxy directive.
+func parseProxy(s string) error {
+	if s == "direct" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q, expected socks5 or http", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing proxy host in %q", s)
+	}
+	return nil
+}
+
+// networkDialer returns the dialer to connect to the server of network with:
+// its proxy, or the one of the environment.
+func networkDialer(network ConfigNetwork) (proxy.Dialer, error) {
+	switch network.Proxy {
+	case "":
+		return proxy.FromEnvironment(), nil
+	case "direct":
+		return proxy.Direct, nil
+	}
+	u, err := url.Parse(network.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.FromURL(u, proxy.Direct)
+}
+
+// httpProxy is a proxy.Dialer connecting through an HTTP proxy with the
+// CONNECT method.
+type httpProxy struct {
+	addr    string
+	auth    string // value of the Proxy-Authorization header, if any.
+	forward proxy.Dialer
+}
+
+func newHTTPProxy(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	p := &httpProxy{
+		addr:    u.Host,
+		forward: forward,
+	}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), "8080")
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := u.User.Username() + ":" + password
+		p.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	return p, nil
+}
+
+func (p *httpProxy) Dial(network, addr string) (net.Conn, error) {
+	conn, err := p.forward.Dial(network, p.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if p.auth != "" {
+		req.Header.Set("Proxy-Authorization", p.auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Read the response byte by byte, so that no data of the tunnel is
+	// buffered away.
+	res, err := http.ReadResponse(bufio.NewReaderSize(oneByteReader{conn}, 1), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy: %s", res.Status)
+	}
+	return conn, nil
+}
+
+// oneByteReader reads at most one byte at a time from conn.
+type oneByteReader struct {
+	conn net.Conn
+}
+
+func (r oneByteReader) Read(b []byte) (int, error) {
+	if len(b) > 1 {
+		b = b[:1]
+	}
+	return r.conn.Read(b)
+}
@@ -0,0 +1,53 @@
+package senpai
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/proxy"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect || req.Host != "irc.example.org:6697" || req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			conn.Write([]byte("HTTP/1.1 403 Forbidden\r\n\r\n"))
+			return
+		}
+		// Send the reply and the first tunneled bytes at once.
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\nhello"))
+	}()
+
+	u, _ := url.Parse("http://user:pass@" + ln.Addr().String())
+	d, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.Dial("tcp", "irc.example.org:6697")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("expected to read the tunneled data, got %q (%v)", buf, err)
+	}
+}
@@ -0,0 +1,98 @@
+package senpai
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// STSPolicy is a Strict Transport Security policy of a host: connections to
+// it must use TLS on Port until Expiry.
+type STSPolicy struct {
+	Port   string
+	Expiry time.Time // zero until senpai exits, for policies not persisted.
+}
+
+// addrWithPort returns the address of the server of network, with the default
+// port if it has none.
+func addrWithPort(network ConfigNetwork) string {
+	addr := network.Addr
+	colonIdx := strings.LastIndexByte(addr, ':')
+	bracketIdx := strings.LastIndexByte(addr, ']')
+	if colonIdx <= bracketIdx {
+		// either colonIdx < 0, or the last colon is before a ']' (end
+		// of IPv6 address. -> missing port
+		if network.TLS {
+			addr += ":6697"
+		} else {
+			addr += ":6667"
+		}
+	}
+	return addr
+}
+
+// applySTS returns network changed to use TLS if its host has an STS policy,
+// and whether it has been changed.
+func (app *App) applySTS(network ConfigNetwork) (ConfigNetwork, bool) {
+	if network.TLS {
+		return network, false
+	}
+	host, _, err := net.SplitHostPort(addrWithPort(network))
+	if err != nil {
+		return network, false
+	}
+	policy, ok := app.stsPolicy(host)
+	if !ok {
+		return network, false
+	}
+	network.TLS = true
+	network.Addr = net.JoinHostPort(host, policy.Port)
+	return network, true
+}
+
+// stsPolicy returns the STS policy of host, if it has one that has not
+// expired.
+func (app *App) stsPolicy(host string) (STSPolicy, bool) {
+	app.stsMu.Lock()
+	policy, ok := app.stsPolicies[host]
+	app.stsMu.Unlock()
+	if !ok || (!policy.Expiry.IsZero() && policy.Expiry.Before(time.Now())) {
+		return STSPolicy{}, false
+	}
+	return policy, true
+}
+
+// setSTSPolicy sets the STS policy of host, or removes it if policy is nil.
+func (app *App) setSTSPolicy(host string, policy *STSPolicy) {
+	app.stsMu.Lock()
+	defer app.stsMu.Unlock()
+	if policy == nil {
+		delete(app.stsPolicies, host)
+	} else {
+		app.stsPolicies[host] = *policy
+	}
+}
+
+// SetSTSPolicies sets the STS policies, by host, saved by a previous run.
+func (app *App) SetSTSPolicies(policies map[string]STSPolicy) {
+	app.stsMu.Lock()
+	defer app.stsMu.Unlock()
+	for host, policy := range policies {
+		if !policy.Expiry.IsZero() && time.Now().Before(policy.Expiry) {
+			app.stsPolicies[host] = policy
+		}
+	}
+}
+
+// STSPolicies returns the STS policies to save for the next run, by host.
+func (app *App) STSPolicies() map[string]STSPolicy {
+	app.stsMu.Lock()
+	defer app.stsMu.Unlock()
+	policies := map[string]STSPolicy{}
+	for host, policy := range app.stsPolicies {
+		if !policy.Expiry.IsZero() && time.Now().Before(policy.Expiry) {
+			policies[host] = policy
+		}
+	}
+	return policies
+}
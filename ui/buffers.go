@@ -12,7 +12,7 @@ import (
 const Overlay = "/overlay"
 
 func IsSplitRune(r rune) bool {
-	return r == ' ' || r == '\t'
+	return r == ' ' || r == '\t' || r == '\n'
 }
 
 type point struct {
@@ -28,6 +28,39 @@ const (
 	NotifyHighlight
 )
 
+// NotifyLevel is which lines of a buffer notify the user, by marking the
+// buffer as unread or as highlighted.
+type NotifyLevel int
+
+const (
+	NotifyLevelAll       NotifyLevel = iota // any line.
+	NotifyLevelHighlight                    // highlights only.
+	NotifyLevelNone                         // no lines.
+)
+
+func (l NotifyLevel) String() string {
+	switch l {
+	case NotifyLevelHighlight:
+		return "highlight"
+	case NotifyLevelNone:
+		return "none"
+	default:
+		return "all"
+	}
+}
+
+// Filter returns the notification of a line of kind notify, in a buffer of
+// level l.
+func (l NotifyLevel) Filter(notify NotifyType) NotifyType {
+	switch {
+	case l == NotifyLevelNone:
+		return NotifyNone
+	case l == NotifyLevelHighlight && notify != NotifyHighlight:
+		return NotifyNone
+	}
+	return notify
+}
+
 type Line struct {
 	At        time.Time
 	Head      string
@@ -38,15 +71,107 @@ type Line struct {
 	Mergeable bool
 	Data      interface{}
 
+	ID      string // msgid of the message, empty if unknown.
+	ReplyTo string // msgid of the message this line replies to, if any.
+
 	splitPoints []point
 	width       int
 	newLines    []int
+
+	reactions []reaction
+	bodyLen   int // length of Body without reaction counters.
+}
+
+// reaction is a reaction sent to a line, and the users who sent it.
+type reaction struct {
+	text  string
+	users map[string]struct{}
 }
 
 func (l *Line) IsZero() bool {
 	return l.Body.string == ""
 }
 
+// quoteParent prefixes the body of l with an excerpt of the line it replies
+// to, searched from the end of lines.
+func (l *Line) quoteParent(lines []Line, st tcell.Style) {
+	if l.ReplyTo == "" {
+		return
+	}
+	excerpt := "unknown message"
+	for i := len(lines) - 1; 0 <= i; i-- {
+		if lines[i].ID == l.ReplyTo {
+			excerpt = strings.ReplaceAll(lines[i].text(), "\n", " ")
+			excerpt = truncate(excerpt, 30, "\u2026")
+			break
+		}
+	}
+	var sb StyledStringBuilder
+	sb.SetStyle(st)
+	sb.WriteString("[\u21aa " + excerpt + "] ")
+	sb.SetStyle(tcell.StyleDefault)
+	sb.WriteStyledString(l.Body)
+	l.Body = sb.StyledString()
+}
+
+// text returns the body of l without reaction counters.
+func (l *Line) text() string {
+	if len(l.reactions) == 0 {
+		return l.Body.string
+	}
+	return l.Body.string[:l.bodyLen]
+}
+
+// addReaction counts the reaction of user, unless it is already counted, and
+// shows the counters at the end of the body of l.
+func (l *Line) addReaction(user, text string, st tcell.Style) {
+	if len(l.reactions) == 0 {
+		l.bodyLen = len(l.Body.string)
+	}
+	found := false
+	for i := range l.reactions {
+		if l.reactions[i].text == text {
+			if _, ok := l.reactions[i].users[user]; ok {
+				// Replayed by history.
+				return
+			}
+			l.reactions[i].users[user] = struct{}{}
+			found = true
+			break
+		}
+	}
+	if !found {
+		l.reactions = append(l.reactions, reaction{
+			text:  text,
+			users: map[string]struct{}{user: {}},
+		})
+	}
+
+	var sb StyledStringBuilder
+	sb.WriteString(l.Body.string[:l.bodyLen])
+	for _, style := range l.Body.styles {
+		if l.bodyLen <= style.Start {
+			break
+		}
+		sb.styles = append(sb.styles, style)
+	}
+	sb.SetStyle(st)
+	sb.WriteString(" [")
+	for i, r := range l.reactions {
+		if i != 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(r.text)
+		if count := len(r.users); 1 < count {
+			sb.WriteString(fmt.Sprintf("\u00d7%d", count))
+		}
+	}
+	sb.WriteString("]")
+	l.Body = sb.StyledString()
+	l.width = 0
+	l.computeSplitPoints()
+}
+
 func (l *Line) computeSplitPoints() {
 	if l.splitPoints == nil {
 		l.splitPoints = []point{}
@@ -111,7 +236,12 @@ func (l *Line) NewLines(width int) []int {
 		sp1 := l.splitPoints[i-1]
 		sp2 := l.splitPoints[i]
 
-		if 0 < len(l.newLines) && x == 0 && sp1.Split {
+		if sp1.Split && strings.ContainsRune(l.Body.string[sp1.I:sp2.I], '\n') {
+			// Lines of multiline messages always start a new row, at
+			// the next word.
+			x = 0
+			l.newLines = append(l.newLines, sp2.I)
+		} else if 0 < len(l.newLines) && x == 0 && sp1.Split {
 			// Except for the first row, let's skip the whitespace at the start
 			// of the row.
 		} else if !sp1.Split && sp2.X-sp1.X == width {
@@ -179,24 +309,49 @@ func (l *Line) NewLines(width int) []int {
 	return l.newLines
 }
 
+// NetworkState is the connection state of a network, shown on its home buffer
+// in buffer lists.
+type NetworkState int
+
+const (
+	NetworkConnected NetworkState = iota
+	NetworkConnecting
+	NetworkDisconnected
+)
+
+// networkStyle returns st changed to show the given network state.
+func networkStyle(st tcell.Style, state NetworkState) tcell.Style {
+	switch state {
+	case NetworkConnecting:
+		return st.Italic(true)
+	case NetworkDisconnected:
+		return st.StrikeThrough(true)
+	default:
+		return st
+	}
+}
+
 type buffer struct {
 	netID      string
 	netName    string
+	netState   NetworkState // only set on home buffers.
+	netRetry   time.Time    // next connection attempt, only set on home buffers.
 	title      string
 	highlights int
 	unread     bool
 	read       time.Time
 	openedOnce bool
 
-	lines []Line
-	topic string
+	lines    []Line
+	topic    string
+	selected string // ID of the selected line, if any.
 
 	scrollAmt int
 	isAtTop   bool
 }
 
 type BufferList struct {
-	colors ConfigColors
+	theme Theme
 
 	list    []buffer
 	overlay *buffer
@@ -213,9 +368,9 @@ type BufferList struct {
 
 // NewBufferList returns a new BufferList.
 // Call Resize() once before using it.
-func NewBufferList(colors ConfigColors, mergeLine func(*Line, Line)) BufferList {
+func NewBufferList(theme Theme, mergeLine func(*Line, Line)) BufferList {
 	return BufferList{
-		colors:      colors,
+		theme:       theme,
 		list:        []buffer{},
 		clicked:     -1,
 		doMergeLine: mergeLine,
@@ -340,6 +495,53 @@ func (bs *BufferList) Remove(netID, title string) bool {
 	return true
 }
 
+// RemoveNetwork removes all the buffers of the given network.
+func (bs *BufferList) RemoveNetwork(netID string) {
+	for i := len(bs.list) - 1; i >= 0; i-- {
+		if bs.list[i].netID != netID {
+			continue
+		}
+		bs.list = append(bs.list[:i], bs.list[i+1:]...)
+		if i < bs.current {
+			bs.current--
+		}
+	}
+	if len(bs.list) <= bs.current {
+		bs.current = len(bs.list) - 1
+	}
+	bs.clicked = -1
+}
+
+func (bs *BufferList) SetNetworkState(netID string, state NetworkState) {
+	_, b := bs.at(netID, "")
+	if b == nil {
+		return
+	}
+	b.netState = state
+}
+
+// SetNetworkRetry sets the time of the next connection attempt to netID,
+// counted down in buffer lists, or removes it if at is zero.
+func (bs *BufferList) SetNetworkRetry(netID string, at time.Time) {
+	_, b := bs.at(netID, "")
+	if b == nil {
+		return
+	}
+	b.netRetry = at
+}
+
+// retryCountdown returns the text showing the time left until at, if set.
+func retryCountdown(at time.Time) string {
+	if at.IsZero() {
+		return ""
+	}
+	left := time.Until(at).Round(time.Second)
+	if left < 0 {
+		left = 0
+	}
+	return " (" + left.String() + ")"
+}
+
 func (bs *BufferList) mergeLine(former *Line, addition Line) (keepLine bool) {
 	bs.doMergeLine(former, addition)
 	if former.Body.string == "" {
@@ -371,6 +573,7 @@ func (bs *BufferList) AddLine(netID, title string, notify NotifyType, line Line)
 		}
 		// TODO change b.scrollAmt if it's not 0 and bs.current is idx.
 	} else {
+		line.quoteParent(b.lines, bs.theme.StatusStyle())
 		line.computeSplitPoints()
 		b.lines = append(b.lines, line)
 		if b == current && 0 < b.scrollAmt {
@@ -402,6 +605,7 @@ func (bs *BufferList) AddLines(netID, title string, before, after []Line) {
 				}
 			} else {
 				if buf != &b.lines {
+					line.quoteParent(lines, bs.theme.StatusStyle())
 					if b.openedOnce {
 						line.Body = line.Body.ParseURLs()
 					}
@@ -414,6 +618,95 @@ func (bs *BufferList) AddLines(netID, title string, before, after []Line) {
 	b.lines = lines
 }
 
+// AddReaction counts the reaction of user, in a canonical form, on the line of
+// the given ID, if it is loaded.
+func (bs *BufferList) AddReaction(netID, title, id, user, reaction string) {
+	_, b := bs.at(netID, title)
+	if b == nil || id == "" {
+		return
+	}
+	for i := len(b.lines) - 1; 0 <= i; i-- {
+		if b.lines[i].ID == id {
+			b.lines[i].addReaction(user, reaction, bs.theme.StatusStyle())
+			return
+		}
+	}
+}
+
+// SelectUp selects the line with an ID that is above the selected one, or the
+// last one if none is selected.
+func (bs *BufferList) SelectUp() {
+	b := bs.cur()
+	i := len(b.lines)
+	if j := b.selectedIndex(); 0 <= j {
+		i = j
+	}
+	for i--; 0 <= i; i-- {
+		if b.lines[i].ID != "" {
+			bs.selectLine(i)
+			return
+		}
+	}
+}
+
+// SelectDown selects the line with an ID that is below the selected one, or
+// removes the selection if there is none.
+func (bs *BufferList) SelectDown() {
+	b := bs.cur()
+	j := b.selectedIndex()
+	if j < 0 {
+		b.selected = ""
+		return
+	}
+	for i := j + 1; i < len(b.lines); i++ {
+		if b.lines[i].ID != "" {
+			bs.selectLine(i)
+			return
+		}
+	}
+	b.selected = ""
+}
+
+// Selected returns the ID of the selected line of the current buffer, or an
+// empty string.
+func (bs *BufferList) Selected() string {
+	return bs.cur().selected
+}
+
+func (bs *BufferList) ClearSelection() {
+	bs.cur().selected = ""
+}
+
+// selectLine selects the i-th line of the current buffer and scrolls the
+// timeline so that it is visible.
+func (bs *BufferList) selectLine(i int) {
+	b := bs.cur()
+	b.selected = b.lines[i].ID
+	below := 0
+	for j := len(b.lines) - 1; i < j; j-- {
+		below += len(b.lines[j].NewLines(bs.tlInnerWidth)) + 1
+	}
+	height := len(b.lines[i].NewLines(bs.tlInnerWidth)) + 1
+	if below < b.scrollAmt {
+		b.scrollAmt = below
+	} else if b.scrollAmt < below+height-bs.tlHeight {
+		b.scrollAmt = below + height - bs.tlHeight
+	}
+}
+
+// selectedIndex returns the index of the selected line, or -1.
+func (b *buffer) selectedIndex() int {
+	if b.selected == "" {
+		return -1
+	}
+	for i := len(b.lines) - 1; 0 <= i; i-- {
+		if b.lines[i].ID == b.selected {
+			return i
+		}
+	}
+	return -1
+}
+
 func (bs *BufferList) SetTopic(netID, title string, topic string) {
 	_, b := bs.at(netID, title)
 	if b == nil {
@@ -556,13 +849,13 @@ func (bs *BufferList) DrawVerticalBufferList(screen tcell.Screen, x0, y0, width,
 		y := y0 + i
 		st := tcell.StyleDefault
 		if b.unread {
-			st = st.Bold(true).Foreground(bs.colors.Unread)
+			st = st.Bold(true).Foreground(bs.theme.Unread)
 		}
 		if bi == bs.current || bi == bs.clicked {
 			st = st.Reverse(true)
 		}
 		if bs.showBufferNumbers {
-			indexSt := st.Foreground(tcell.ColorGray)
+			indexSt := st.Foreground(bs.theme.Status)
 			indexText := fmt.Sprintf("%d:", bi)
 			printString(screen, &x, y, Styled(indexText, indexSt))
 			x = x0 + indexPadding
@@ -570,7 +863,8 @@ func (bs *BufferList) DrawVerticalBufferList(screen tcell.Screen, x0, y0, width,
 
 		var title string
 		if b.title == "" {
-			title = b.netName
+			st = networkStyle(st, b.netState)
+			title = b.netName + retryCountdown(b.netRetry)
 		} else {
 			if bi == bs.current || bi == bs.clicked {
 				screen.SetContent(x, y, ' ', nil, tcell.StyleDefault.Reverse(true))
@@ -591,7 +885,7 @@ func (bs *BufferList) DrawVerticalBufferList(screen tcell.Screen, x0, y0, width,
 		}
 
 		if b.highlights != 0 {
-			highlightSt := st.Foreground(tcell.ColorRed).Reverse(true)
+			highlightSt := st.Foreground(bs.theme.Highlight).Reverse(true)
 			highlightText := fmt.Sprintf(" %d ", b.highlights)
 			x = x0 + width - len(highlightText)
 			printString(screen, &x, y, Styled(highlightText, highlightSt))
@@ -650,7 +944,7 @@ func (bs *BufferList) DrawHorizontalBufferList(screen tcell.Screen, x0, y0, widt
 		}
 		st := tcell.StyleDefault
 		if b.unread {
-			st = st.Bold(true).Foreground(bs.colors.Unread)
+			st = st.Bold(true).Foreground(bs.theme.Unread)
 		} else if i == bs.current {
 			st = st.Underline(true)
 		}
@@ -660,8 +954,8 @@ func (bs *BufferList) DrawHorizontalBufferList(screen tcell.Screen, x0, y0, widt
 
 		var title string
 		if b.title == "" {
-			st = st.Dim(true)
-			title = b.netName
+			st = networkStyle(st.Dim(true), b.netState)
+			title = b.netName + retryCountdown(b.netRetry)
 		} else {
 			title = b.title
 		}
@@ -669,7 +963,7 @@ func (bs *BufferList) DrawHorizontalBufferList(screen tcell.Screen, x0, y0, widt
 		printString(screen, &x, y0, Styled(title, st))
 
 		if 0 < b.highlights {
-			st = st.Foreground(tcell.ColorRed).Reverse(true)
+			st = st.Foreground(bs.theme.Highlight).Reverse(true)
 			screen.SetContent(x, y0, ' ', nil, st)
 			x++
 			printNumber(screen, &x, y0, st, b.highlights)
@@ -700,7 +994,7 @@ func (bs *BufferList) DrawTimeline(screen tcell.Screen, x0, y0, nickColWidth int
 	printString(screen, &xTopic, y0, Styled(b.topic, tcell.StyleDefault))
 	y0++
 	for x := x0; x < x0+bs.tlInnerWidth+nickColWidth+9; x++ {
-		st := tcell.StyleDefault.Foreground(tcell.ColorGray)
+		st := bs.theme.StatusStyle()
 		screen.SetContent(x, y0, 0x2500, nil, st)
 	}
 	y0++
@@ -722,7 +1016,10 @@ func (bs *BufferList) DrawTimeline(screen tcell.Screen, x0, y0, nickColWidth int
 
 		if yi >= y0 {
 			st := tcell.StyleDefault.Bold(true)
-			printTime(screen, x0, yi, st, line.At.Local())
+			printTime(screen, x0, yi, bs.theme.StatusStyle(), line.At.Local())
+			if line.ID != "" && line.ID == b.selected {
+				screen.SetContent(x0+8, yi, '>', nil, st)
+			}
 		}
 
 		x := x1
@@ -747,6 +1044,9 @@ func (bs *BufferList) DrawTimeline(screen tcell.Screen, x0, y0, nickColWidth int
 			if y != yi && x == x1 && IsSplitRune(r) {
 				continue
 			}
+			if r == '\n' {
+				continue
+			}
 
 			if y >= y0 {
 				screen.SetContent(x, y, r, nil, style)
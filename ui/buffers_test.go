@@ -142,3 +142,81 @@ func TestRenderedHeight(t *testing.T) {
 
 	assertNewLines(t, "cc en direct du word wrapping des familles le tests ça v a va va v a va", 46, 2)
 }
+
+func TestRemoveNetwork(t *testing.T) {
+	bs := NewBufferList(Theme{}, func(*Line, Line) {})
+	bs.Add("", "(home)", "")
+	bs.Add("1", "a", "")
+	bs.Add("1", "", "#a")
+	bs.Add("2", "b", "")
+	bs.Add("2", "", "#b")
+	bs.To(4)
+
+	bs.RemoveNetwork("1")
+
+	if len(bs.list) != 3 {
+		t.Fatalf("expected 3 buffers, got %d", len(bs.list))
+	}
+	for _, b := range bs.list {
+		if b.netID == "1" {
+			t.Errorf("buffer %q of the removed network is still there", b.title)
+		}
+	}
+	if netID, title := bs.Current(); netID != "2" || title != "#b" {
+		t.Errorf("expected the current buffer to stay 2/#b, got %s/%s", netID, title)
+	}
+}
+
+func TestRepliesAndReactions(t *testing.T) {
+	bs := NewBufferList(Theme{}, func(*Line, Line) {})
+	bs.Add("", "(home)", "#senpai")
+	bs.To(0)
+
+	bs.AddLine("", "#senpai", NotifyNone, Line{Body: PlainString("<dan> hello"), ID: "1"})
+	bs.AddLine("", "#senpai", NotifyNone, Line{Body: PlainString("<ann> hi"), ID: "2", ReplyTo: "1"})
+	bs.AddReaction("", "#senpai", "1", "ann", "+1")
+	bs.AddReaction("", "#senpai", "1", "bob", "+1")
+	bs.AddReaction("", "#senpai", "1", "ann", "<3")
+	// Reactions replayed by history are not counted again.
+	bs.AddReaction("", "#senpai", "1", "ann", "+1")
+	bs.AddReaction("", "#senpai", "1", "ann", "<3")
+
+	_, b := bs.at("", "#senpai")
+	if body := b.lines[0].Body.String(); body != "<dan> hello [+1×2 <3]" {
+		t.Errorf("unexpected reaction counters: %q", body)
+	}
+	if body := b.lines[1].Body.String(); body != "[↪ <dan> hello] <ann> hi" {
+		t.Errorf("unexpected reply: %q", body)
+	}
+
+	bs.SelectUp()
+	bs.SelectUp()
+	if id := bs.Selected(); id != "1" {
+		t.Errorf("expected line 1 to be selected, got %q", id)
+	}
+	bs.SelectDown()
+	bs.SelectDown()
+	if id := bs.Selected(); id != "" {
+		t.Errorf("expected no selection, got %q", id)
+	}
+}
+
+func TestNotifyLevelFilter(t *testing.T) {
+	tests := []struct {
+		level    NotifyLevel
+		notify   NotifyType
+		expected NotifyType
+	}{
+		{NotifyLevelAll, NotifyUnread, NotifyUnread},
+		{NotifyLevelAll, NotifyHighlight, NotifyHighlight},
+		{NotifyLevelHighlight, NotifyUnread, NotifyNone},
+		{NotifyLevelHighlight, NotifyHighlight, NotifyHighlight},
+		{NotifyLevelNone, NotifyUnread, NotifyNone},
+		{NotifyLevelNone, NotifyHighlight, NotifyNone},
+	}
+	for _, test := range tests {
+		if notify := test.level.Filter(test.notify); notify != test.expected {
+			t.Errorf("%s: expected %d to become %d, got %d", test.level, test.notify, test.expected, notify)
+		}
+	}
+}
@@ -43,7 +43,7 @@ func printNumber(screen tcell.Screen, x *int, y int, st tcell.Style, n int) {
 }
 
 func printTime(screen tcell.Screen, x int, y int, st tcell.Style, t time.Time) {
-	printString(screen, &x, y, Styled(t.Format("15:04:05"), tcell.StyleDefault.Foreground(tcell.ColorGray)))
+	printString(screen, &x, y, Styled(t.Format("15:04:05"), st))
 }
 
 func clearArea(screen tcell.Screen, x0, y0, width, height int) {
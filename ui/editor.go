@@ -41,6 +41,8 @@ type Editor struct {
 	backsearch        bool
 	backsearchPattern []rune // pre-lowercased
 	backsearchIdx     int
+
+	newlineColor tcell.Color // color of the symbol shown for newlines.
 }
 
 // NewEditor returns a new Editor.
@@ -105,7 +107,7 @@ func (e *Editor) putRune(r rune) {
 	copy(e.text[e.lineIdx][e.cursorIdx+1:], e.text[e.lineIdx][e.cursorIdx:])
 	e.text[e.lineIdx][e.cursorIdx] = r
 
-	rw := runeWidth(r)
+	rw := runeWidth(editorRune(r))
 	tw := e.textWidth[len(e.textWidth)-1]
 	e.textWidth = append(e.textWidth, tw+rw)
 	for i := e.cursorIdx + 1; i < len(e.textWidth); i++ {
@@ -417,7 +419,7 @@ func (e *Editor) computeTextWidth() {
 	e.textWidth = e.textWidth[:1]
 	rw := 0
 	for _, r := range e.text[e.lineIdx] {
-		rw += runeWidth(r)
+		rw += runeWidth(editorRune(r))
 		e.textWidth = append(e.textWidth, rw)
 	}
 }
@@ -434,6 +436,10 @@ func (e *Editor) Draw(screen tcell.Screen, x0, y int) {
 		if e.backsearch && i < e.cursorIdx && i >= e.cursorIdx-len(e.backsearchPattern) {
 			s = s.Underline(true)
 		}
+		if r == '\n' {
+			s = s.Foreground(e.newlineColor)
+		}
+		r = editorRune(r)
 		screen.SetContent(x, y, r, nil, s)
 		x += runeWidth(r)
 		i++
@@ -448,6 +454,15 @@ func (e *Editor) Draw(screen tcell.Screen, x0, y int) {
 	screen.ShowCursor(cursorX, y)
 }
 
+// editorRune returns the rune shown in place of r, so that newlines of
+// multiline messages are visible.
+func editorRune(r rune) rune {
+	if r == '\n' {
+		return '\u21b5'
+	}
+	return r
+}
+
 // runeOffset returns the lowercase version of a rune
 // TODO: len(strings.ToLower(string(r))) == len(strings.ToUpper(string(r))) for all x?
 func runeToLower(r rune) rune {
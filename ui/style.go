@@ -189,6 +189,45 @@ func (s StyledString) ParseURLs() StyledString {
 	}
 }
 
+// Restyle returns s with the style of the bytes from start to end changed by
+// f, for example to emphasize them.
+func (s StyledString) Restyle(start, end int, f func(tcell.Style) tcell.Style) StyledString {
+	if len(s.string) < end {
+		end = len(s.string)
+	}
+	if end <= start {
+		return s
+	}
+
+	styles := make([]rangedStyle, 0, len(s.styles)+2)
+	current := tcell.StyleDefault
+	i := 0
+	for ; i < len(s.styles) && s.styles[i].Start < start; i++ {
+		current = s.styles[i].Style
+		styles = append(styles, s.styles[i])
+	}
+	styles = append(styles, rangedStyle{Start: start, Style: f(current)})
+	for ; i < len(s.styles) && s.styles[i].Start < end; i++ {
+		current = s.styles[i].Style
+		if s.styles[i].Start == styles[len(styles)-1].Start {
+			// a style already starts at this position, edit it
+			styles[len(styles)-1].Style = f(current)
+			continue
+		}
+		styles = append(styles, rangedStyle{Start: s.styles[i].Start, Style: f(current)})
+	}
+	if end < len(s.string) && (i == len(s.styles) || s.styles[i].Start != end) {
+		// restore the style that was effective at end
+		styles = append(styles, rangedStyle{Start: end, Style: current})
+	}
+	styles = append(styles, s.styles[i:]...)
+
+	return StyledString{
+		string: s.string,
+		styles: styles,
+	}
+}
+
 func isDigit(c byte) bool {
 	return '0' <= c && c <= '9'
 }
@@ -94,3 +94,49 @@ func TestIRCString(t *testing.T) {
 		},
 	})
 }
+
+func TestRestyle(t *testing.T) {
+	bold := func(st tcell.Style) tcell.Style {
+		return st.Bold(true)
+	}
+	red := tcell.StyleDefault.Foreground(tcell.ColorRed)
+
+	s := PlainString("hello al, hi").Restyle(6, 8, bold)
+	expected := []rangedStyle{
+		{Start: 6, Style: tcell.StyleDefault.Bold(true)},
+		{Start: 8, Style: tcell.StyleDefault},
+	}
+	if !equalStyles(s.styles, expected) {
+		t.Errorf("plain string: expected styles %+v, got %+v", expected, s.styles)
+	}
+
+	s = IRCString("\x034hello al\x03, hi").Restyle(6, 8, bold)
+	expected = []rangedStyle{
+		{Start: 0, Style: red},
+		{Start: 6, Style: red.Bold(true)},
+		{Start: 8, Style: tcell.StyleDefault},
+	}
+	if !equalStyles(s.styles, expected) {
+		t.Errorf("colored string: expected styles %+v, got %+v", expected, s.styles)
+	}
+
+	s = IRCString("hello \x034al").Restyle(6, 8, bold)
+	expected = []rangedStyle{
+		{Start: 6, Style: red.Bold(true)},
+	}
+	if !equalStyles(s.styles, expected) {
+		t.Errorf("string ending with the span: expected styles %+v, got %+v", expected, s.styles)
+	}
+}
+
+func equalStyles(a, b []rangedStyle) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package ui
+
+import (
+	"hash/fnv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Theme is the set of colors of the interface.  tcell.ColorDefault is the
+// default color of the terminal.
+type Theme struct {
+	Prompt     tcell.Color // prompt shown when typing commands.
+	Unread     tcell.Color // names of unread buffers.
+	Status     tcell.Color // status lines, timestamps, separators, away members...
+	Error      tcell.Color // errors, offline prompt, disconnected members.
+	Highlight  tcell.Color // highlight counters, words that highlight you.
+	Join       tcell.Color // joins in the timeline.
+	Part       tcell.Color // parts and quits in the timeline.
+	PowerLevel tcell.Color // power levels of members, such as "@".
+
+	// Nicks are the colors nicknames are given, depending on their hash.
+	// No colors leaves nicknames in the default color.
+	Nicks []tcell.Color
+	// NickTable are the colors given to some nicknames, by lowercase
+	// nickname, instead of one of Nicks.
+	NickTable map[string]tcell.Color
+}
+
+// DarkTheme returns colors suited to terminals with a dark background.
+func DarkTheme() Theme {
+	nicks := make([]tcell.Color, 15)
+	for i := range nicks {
+		nicks[i] = tcell.PaletteColor(i + 1)
+	}
+	return Theme{
+		Prompt:     tcell.ColorDefault,
+		Unread:     tcell.ColorDefault,
+		Status:     tcell.ColorGray,
+		Error:      tcell.ColorRed,
+		Highlight:  tcell.ColorRed,
+		Join:       tcell.ColorGreen,
+		Part:       tcell.ColorRed,
+		PowerLevel: tcell.ColorGreen,
+		Nicks:      nicks,
+		NickTable:  map[string]tcell.Color{},
+	}
+}
+
+// LightTheme returns colors suited to terminals with a light background,
+// without the pale colors of DarkTheme.
+func LightTheme() Theme {
+	return Theme{
+		Prompt:     tcell.ColorDefault,
+		Unread:     tcell.ColorDefault,
+		Status:     tcell.PaletteColor(242),
+		Error:      tcell.ColorMaroon,
+		Highlight:  tcell.ColorMaroon,
+		Join:       tcell.ColorGreen,
+		Part:       tcell.ColorMaroon,
+		PowerLevel: tcell.ColorGreen,
+		Nicks: []tcell.Color{
+			tcell.ColorMaroon,
+			tcell.ColorGreen,
+			tcell.ColorOlive,
+			tcell.ColorNavy,
+			tcell.ColorPurple,
+			tcell.ColorTeal,
+			tcell.ColorRed,
+			tcell.ColorBlue,
+			tcell.ColorFuchsia,
+			tcell.PaletteColor(130), // dark orange
+			tcell.PaletteColor(24),  // deep sky blue
+			tcell.PaletteColor(89),  // deep pink
+		},
+		NickTable: map[string]tcell.Color{},
+	}
+}
+
+// NickColor returns the color of nick.
+func (t *Theme) NickColor(nick string) tcell.Color {
+	if c, ok := t.NickTable[strings.ToLower(nick)]; ok {
+		return c
+	}
+	if len(t.Nicks) == 0 {
+		return tcell.ColorDefault
+	}
+	h := fnv.New32()
+	_, _ = h.Write([]byte(nick))
+	return t.Nicks[h.Sum32()%uint32(len(t.Nicks))]
+}
+
+// HighlightStyle returns st changed to emphasize the words of messages that
+// highlight the user.
+func (t *Theme) HighlightStyle(st tcell.Style) tcell.Style {
+	return st.Foreground(t.Highlight).Bold(true)
+}
+
+// StatusStyle returns the style of status text.
+func (t *Theme) StatusStyle() tcell.Style {
+	return tcell.StyleDefault.Foreground(t.Status)
+}
@@ -0,0 +1,32 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestNickColor(t *testing.T) {
+	theme := DarkTheme()
+	theme.NickTable["senpai"] = tcell.ColorYellow
+
+	if c := theme.NickColor("Senpai"); c != tcell.ColorYellow {
+		t.Errorf("expected the color of the nick table, got %v", c)
+	}
+	c := theme.NickColor("kouhai")
+	found := false
+	for _, nc := range theme.Nicks {
+		found = found || nc == c
+	}
+	if !found {
+		t.Errorf("expected a color of the palette, got %v", c)
+	}
+	if theme.NickColor("kouhai") != c {
+		t.Errorf("expected the same color for the same nick")
+	}
+
+	theme.Nicks = nil
+	if c := theme.NickColor("kouhai"); c != tcell.ColorDefault {
+		t.Errorf("expected the default color without palette, got %v", c)
+	}
+}
@@ -19,11 +19,8 @@ type Config struct {
 	AutoComplete     func(cursorIdx int, text []rune) []Completion
 	Mouse            bool
 	MergeLine        func(former *Line, addition Line)
-	Colors           ConfigColors
-}
-
-type ConfigColors struct {
-	Unread tcell.Color
+	NotifyLevel      func(netID, buffer string) NotifyLevel
+	Theme            Theme
 }
 
 type UI struct {
@@ -89,8 +86,9 @@ func New(config Config) (ui *UI, err error) {
 		close(ui.Events)
 	}()
 
-	ui.bs = NewBufferList(config.Colors, ui.config.MergeLine)
+	ui.bs = NewBufferList(config.Theme, ui.config.MergeLine)
 	ui.e = NewEditor(ui.config.AutoComplete)
+	ui.e.newlineColor = config.Theme.Status
 	ui.Resize()
 
 	return
@@ -273,7 +271,23 @@ func (ui *UI) RemoveBuffer(netID, title string) {
 	ui.memberOffset = 0
 }
 
+func (ui *UI) RemoveNetwork(netID string) {
+	ui.bs.RemoveNetwork(netID)
+	ui.memberOffset = 0
+}
+
+func (ui *UI) SetNetworkState(netID string, state NetworkState) {
+	ui.bs.SetNetworkState(netID, state)
+}
+
+func (ui *UI) SetNetworkRetry(netID string, at time.Time) {
+	ui.bs.SetNetworkRetry(netID, at)
+}
+
 func (ui *UI) AddLine(netID, buffer string, notify NotifyType, line Line) {
+	if ui.config.NotifyLevel != nil {
+		notify = ui.config.NotifyLevel(netID, buffer).Filter(notify)
+	}
 	ui.bs.AddLine(netID, buffer, notify, line)
 }
 
@@ -281,6 +295,26 @@ func (ui *UI) AddLines(netID, buffer string, before, after []Line) {
 	ui.bs.AddLines(netID, buffer, before, after)
 }
 
+func (ui *UI) AddReaction(netID, buffer, id, user, reaction string) {
+	ui.bs.AddReaction(netID, buffer, id, user, reaction)
+}
+
+func (ui *UI) SelectUp() {
+	ui.bs.SelectUp()
+}
+
+func (ui *UI) SelectDown() {
+	ui.bs.SelectDown()
+}
+
+func (ui *UI) SelectedLine() string {
+	return ui.bs.Selected()
+}
+
+func (ui *UI) ClearSelection() {
+	ui.bs.ClearSelection()
+}
+
 func (ui *UI) JumpBuffer(sub string) bool {
 	subLower := strings.ToLower(sub)
 	for i, b := range ui.bs.list {
@@ -474,7 +508,7 @@ func (ui *UI) drawStatusBar(x0, y, width int) {
 	}
 
 	var s StyledStringBuilder
-	s.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+	s.SetStyle(ui.config.Theme.StatusStyle())
 	s.WriteString("--")
 
 	x := x0 + 5 + ui.config.NickColWidth
@@ -482,7 +516,7 @@ func (ui *UI) drawStatusBar(x0, y, width int) {
 	x += 2
 
 	s.Reset()
-	s.SetStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+	s.SetStyle(ui.config.Theme.StatusStyle())
 	s.WriteString(ui.status)
 
 	printString(ui.screen, &x, y, s.StyledString())
@@ -517,12 +551,12 @@ func (ui *UI) drawVerticalMemberList(screen tcell.Screen, x0, y0, width, height
 		x := x0
 		y := y0 + i
 		if m.Disconnected {
-			disconnectedSt := tcell.StyleDefault.Foreground(tcell.ColorRed).Reverse(reverse)
+			disconnectedSt := tcell.StyleDefault.Foreground(ui.config.Theme.Error).Reverse(reverse)
 			printString(screen, &x, y, Styled("\u274C", disconnectedSt))
 		} else if m.PowerLevel != "" {
 			x += padding - 1
 			powerLevelText := m.PowerLevel[:1]
-			powerLevelSt := tcell.StyleDefault.Foreground(tcell.ColorGreen).Reverse(reverse)
+			powerLevelSt := tcell.StyleDefault.Foreground(ui.config.Theme.PowerLevel).Reverse(reverse)
 			printString(screen, &x, y, Styled(powerLevelText, powerLevelSt))
 		} else {
 			x += padding
@@ -531,7 +565,7 @@ func (ui *UI) drawVerticalMemberList(screen tcell.Screen, x0, y0, width, height
 		var name StyledString
 		nameText := truncate(m.Name.Name, width-1, "\u2026")
 		if m.Away {
-			name = Styled(nameText, tcell.StyleDefault.Foreground(tcell.ColorGray).Reverse(reverse))
+			name = Styled(nameText, ui.config.Theme.StatusStyle().Reverse(reverse))
 		} else {
 			name = Styled(nameText, tcell.StyleDefault.Reverse(reverse))
 		}
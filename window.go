@@ -1,7 +1,7 @@
 package senpai
 
 import (
-	"hash/fnv"
+	"fmt"
 	"strings"
 	"time"
 
@@ -13,6 +13,9 @@ const welcomeMessage = "senpai dev build. See senpai(1) for a list of keybinding
 
 func (app *App) initWindow() {
 	app.win.AddBuffer("", "(home)", "")
+	for _, network := range app.cfg.Networks {
+		app.win.AddBuffer(network.Name, network.Name, "")
+	}
 	app.win.AddLine("", "", ui.NotifyNone, ui.Line{
 		Head: "--",
 		Body: ui.PlainString(welcomeMessage),
@@ -47,6 +50,10 @@ func (app *App) addStatusLine(netID string, line ui.Line) {
 }
 
 func (app *App) setStatus() {
+	if len(app.pasteQueue) != 0 {
+		app.win.SetStatus(fmt.Sprintf("sending pasted lines, %d left... (ESCAPE to cancel)", len(app.pasteQueue)))
+		return
+	}
 	netID, buffer := app.win.CurrentBuffer()
 	s := app.sessions[netID]
 	if s == nil {
@@ -66,9 +73,38 @@ func (app *App) setStatus() {
 			status += ts[len(ts)-1] + verb
 		}
 	}
+	if n := s.Pending(); n != 0 {
+		status = fmt.Sprintf("sending, %d messages left...", n)
+		app.refreshLater()
+	}
+	if status == "" && s.Away() {
+		status = "you are marked as away"
+	}
+	if lag := s.Lag(); status == "" && lag != 0 {
+		status = "lag: " + lag.Round(time.Millisecond).String()
+	}
 	app.win.SetStatus(status)
 }
 
+// refreshTick is sent by app.refreshTimer to draw the interface again, to
+// update the number of messages waiting to be sent and the reconnection
+// countdowns.
+type refreshTick struct{}
+
+// refreshLater makes the event loop draw the interface again in a moment,
+// even if nothing happens.
+func (app *App) refreshLater() {
+	if app.refreshTimer != nil {
+		return
+	}
+	app.refreshTimer = time.AfterFunc(500*time.Millisecond, func() {
+		app.events <- event{
+			src:     "*",
+			content: refreshTick{},
+		}
+	})
+}
+
 func (app *App) setBufferNumbers() {
 	input := app.win.InputContent()
 	if !isCommand(input) {
@@ -87,14 +123,8 @@ func (app *App) setBufferNumbers() {
 	app.win.ShowBufferNumbers(showBufferNumbers)
 }
 
-func identColor(ident string) tcell.Color {
-	h := fnv.New32()
-	_, _ = h.Write([]byte(ident))
-	return tcell.Color((h.Sum32()%15)+1) + tcell.ColorValid
-}
-
-func identString(ident string) ui.StyledString {
-	color := identColor(ident)
+func (app *App) identString(ident string) ui.StyledString {
+	color := app.cfg.Theme.NickColor(ident)
 	style := tcell.StyleDefault.Foreground(color)
 	return ui.Styled(ident, style)
 }